@@ -0,0 +1,326 @@
+package dawn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/sugawarayuuta/sonnet"
+)
+
+// An actionCache is a content-addressed cache of function results. The cache is stored in a
+// directory with two subdirectories:
+//
+//   - actions, which holds the results of functions keyed by their action keys, and
+//   - blobs, which holds the contents of generated files keyed by their SHA-256 sums.
+//
+// A function's action key is a digest of its label, its environment, the paths of the files it
+// generates, and the data of its dependencies. If a function's action key matches an entry in
+// the cache, its generated files are restored from the cache instead of running the function.
+//
+// An actionCache may be backed by a remote cache. Results that are not present in the local
+// cache are fetched from the remote cache, and--if the remote cache is writable--new results
+// are written to both caches.
+//
+// A nil *actionCache is valid and caches nothing.
+type actionCache struct {
+	dir    string
+	remote *remoteCache
+}
+
+// An actionResult records the results of a function.
+type actionResult struct {
+	// Data is the function's target data.
+	Data string `json:"data"`
+	// Outputs holds the function's generated files, keyed by their project-relative paths.
+	Outputs map[string]actionOutput `json:"outputs"`
+	// Discovered holds the sums of the sources listed in the function's depfile, keyed by their
+	// project-relative paths.
+	Discovered map[string]string `json:"discovered,omitempty"`
+	// Required holds the stamps of the targets required by the function, keyed by label.
+	Required map[string]string `json:"required,omitempty"`
+}
+
+// An actionOutput records the contents and permission bits of a generated file.
+type actionOutput struct {
+	Sum  string      `json:"sum"`
+	Mode fs.FileMode `json:"mode"`
+}
+
+func newActionCache(dir string) *actionCache {
+	return &actionCache{dir: dir}
+}
+
+// validSum returns true if the given string is a hex-encoded SHA-256 sum. Action keys and blob
+// sums are used to form paths within the cache directory, so they must be validated before use.
+func validSum(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range sum {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *actionCache) actionPath(key string) (string, error) {
+	if !validSum(key) {
+		return "", fmt.Errorf("invalid action key %q", key)
+	}
+	return filepath.Join(c.dir, "actions", key[:2], key), nil
+}
+
+func (c *actionCache) blobPath(sum string) (string, error) {
+	if !validSum(sum) {
+		return "", fmt.Errorf("invalid blob sum %q", sum)
+	}
+	return filepath.Join(c.dir, "blobs", sum[:2], sum), nil
+}
+
+// load returns the cached result for the given action key, if any. If the result is not present
+// in the local cache, it is fetched from the remote cache along with the contents of its
+// outputs. The remote return value is true if the result was fetched from the remote cache.
+func (c *actionCache) load(ctx context.Context, key string) (result *actionResult, remote, ok bool) {
+	if c == nil {
+		return nil, false, false
+	}
+
+	actionPath, err := c.actionPath(key)
+	if err != nil {
+		return nil, false, false
+	}
+
+	if result, ok := c.loadLocal(actionPath); ok {
+		return result, false, true
+	}
+	if c.remote == nil {
+		return nil, false, false
+	}
+
+	// Failures to read from the remote cache and malformed results are treated as misses.
+	var buf bytes.Buffer
+	if ok, err := c.remote.get(ctx, "ac", key, &buf); !ok || err != nil {
+		return nil, false, false
+	}
+	contents := buf.Bytes()
+
+	result = &actionResult{}
+	if err := sonnet.Unmarshal(contents, result); err != nil {
+		return nil, false, false
+	}
+	for _, output := range result.Outputs {
+		if !validSum(output.Sum) {
+			return nil, false, false
+		}
+	}
+	for _, output := range result.Outputs {
+		if err := c.fetchBlob(ctx, output.Sum); err != nil {
+			return nil, false, false
+		}
+	}
+
+	err = c.write(actionPath, func(w io.Writer) error {
+		_, err := w.Write(contents)
+		return err
+	})
+	if err != nil {
+		return nil, false, false
+	}
+	return result, true, true
+}
+
+func (c *actionCache) loadLocal(actionPath string) (*actionResult, bool) {
+	//nolint:gosec
+	f, err := os.Open(actionPath)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var result actionResult
+	if err := sonnet.NewDecoder(f).Decode(&result); err != nil {
+		return nil, false
+	}
+	return &result, true
+}
+
+// fetchBlob fetches the blob with the given sum from the remote cache into the local cache.
+func (c *actionCache) fetchBlob(ctx context.Context, sum string) error {
+	dest, err := c.blobPath(sum)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+
+	return c.write(dest, func(w io.Writer) error {
+		h := sha256.New()
+		ok, err := c.remote.get(ctx, "cas", sum, io.MultiWriter(w, h))
+		switch {
+		case err != nil:
+			return err
+		case !ok:
+			return fmt.Errorf("missing blob %v", sum)
+		case hex.EncodeToString(h.Sum(nil)) != sum:
+			return fmt.Errorf("corrupt blob %v", sum)
+		}
+		return nil
+	})
+}
+
+// store records the result for the given action key. files maps the project-relative path of
+// each output in the result to its absolute path. If the remote cache is writable, the result
+// is also written to the remote cache.
+func (c *actionCache) store(ctx context.Context, key string, result *actionResult, files map[string]string) error {
+	if c == nil {
+		return nil
+	}
+
+	for rel, output := range result.Outputs {
+		if err := c.putBlob(files[rel], output.Sum); err != nil {
+			return fmt.Errorf("caching %v: %w", rel, err)
+		}
+	}
+
+	actionPath, err := c.actionPath(key)
+	if err != nil {
+		return err
+	}
+	err = c.write(actionPath, func(w io.Writer) error {
+		return sonnet.NewEncoder(w).Encode(result)
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.remote == nil || !c.remote.writable {
+		return nil
+	}
+
+	// Write the outputs before the result so that the remote cache never holds a result whose
+	// outputs are missing.
+	for _, output := range result.Outputs {
+		blobPath, err := c.blobPath(output.Sum)
+		if err != nil {
+			return err
+		}
+		if err := c.remote.putFile(ctx, "cas", output.Sum, blobPath); err != nil {
+			return err
+		}
+	}
+	return c.remote.putFile(ctx, "ac", key, actionPath)
+}
+
+// putBlob adds the file at the given path to the cache's blobs. If the contents of the file
+// do not match the given sum, the file is not added.
+func (c *actionCache) putBlob(path, sum string) error {
+	dest, err := c.blobPath(sum)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.write(dest, func(w io.Writer) error {
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(w, h), f); err != nil {
+			return err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != sum {
+			return errors.New("file changed while it was being cached")
+		}
+		return nil
+	})
+}
+
+// write atomically writes the file at the given path using the contents produced by the given
+// callback.
+func (c *actionCache) write(path string, contents func(w io.Writer) error) error {
+	temp := filepath.Join(c.dir, "temp")
+	if err := os.MkdirAll(temp, 0o750); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(temp, "")
+	if err != nil {
+		return err
+	}
+	tempName := f.Name()
+
+	if err = contents(f); err != nil {
+		f.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	return os.Rename(tempName, path)
+}
+
+// restore restores a generated file to the given path from the cache's blobs. The file is
+// first written to the given temporary directory, then moved into place.
+func (c *actionCache) restore(output actionOutput, path, temp string) error {
+	blobPath, err := c.blobPath(output.Sum)
+	if err != nil {
+		return err
+	}
+	//nolint:gosec
+	blob, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if err := os.MkdirAll(temp, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(temp, "")
+	if err != nil {
+		return err
+	}
+	tempName := f.Name()
+	defer os.Remove(tempName)
+
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(f, h), blob); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != output.Sum {
+		return fmt.Errorf("corrupt cache entry %v", output.Sum)
+	}
+
+	if err = os.Chmod(tempName, output.Mode.Perm()); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(tempName, path)
+}
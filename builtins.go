@@ -161,6 +161,38 @@ func (proj *Project) newBuiltin_target() *starlark.Builtin {
     :param always: True if the target should always be considered out-of-date.
     :param docs: the docs for the target. Normally picked up from the
                  function's docstring.
+    :param resources: the resources the target requires while it runs. Must be
+                      a mapping from resource pool names to amounts. Unless
+                      specified, a target requires one unit of the cpu pool.
+    :param timeout: the maximum duration of each attempt to run the target,
+                    e.g. "10m". When the timeout elapses, the attempt is
+                    cancelled and any processes it started are
+                    interrupted, then killed if they have not exited after
+                    five seconds.
+    :param retries: the number of times to retry the target if it fails.
+    :param service: True if the target is a long-running service. A service's
+                    function runs in the background, and the service is
+                    restarted each time it is re-run. Services may not
+                    generate files or specify depfiles, timeouts, or
+                    retries.
+    :param sandbox: True if the processes the target runs using os.exec and
+                    sh.exec should be sandboxed. Sandboxed processes may
+                    only read the target's sources and the files generated
+                    by its dependencies, and may only write the files the
+                    target generates. Sandboxing is only supported on Linux.
+    :param env: the names of the environment variables that the target
+                depends on. The target is re-run if the value of any of
+                these variables changes.
+    :param tools: the names of the executables that the target depends on.
+                  Each name is resolved using the PATH environment
+                  variable (see os.look_path), and the target is re-run if
+                  the resolved executable or its contents change.
+    :param depfile: a Makefile-style dependency file written by the target,
+                    e.g. by a C compiler's -MD option. The path is
+                    interpreted identically to those in the sources
+                    parameter. After the target runs, the prerequisites
+                    listed in the file are treated as additional sources,
+                    and the target is re-run if any of them change.
 
     :returns: the new build target object or a decorator if function is None.
     `
@@ -184,12 +216,59 @@ func (proj *Project) starlark_builtin_target(thread *starlark.Thread, fn *starla
 		always bool
 
 		docs string
+
+		resources starlark.IterableMapping
+
+		timeout string
+
+		retries int
+
+		service bool
+
+		sandbox bool
+
+		env util.StringList
+
+		tools util.StringList
+
+		depfile string
 	)
-	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name??", &name, "deps??", &deps, "sources??", &sources, "generates??", &generates, "function??", &function, "default??", &default_, "always??", &always, "docs??", &docs); err != nil {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name??", &name, "deps??", &deps, "sources??", &sources, "generates??", &generates, "function??", &function, "default??", &default_, "always??", &always, "docs??", &docs, "resources??", &resources, "timeout??", &timeout, "retries??", &retries, "service??", &service, "sandbox??", &sandbox, "env??", &env, "tools??", &tools, "depfile??", &depfile); err != nil {
+		return nil, err
+	}
+
+	val, err := proj.builtin_target(thread, fn, name, deps, sources, generates, function, default_, always, docs, resources, timeout, retries, service, sandbox, env, tools, depfile)
+	if err != nil {
+		return nil, &starlark.EvalError{Msg: err.Error(), CallStack: thread.CallStack()}
+	}
+	return val, nil
+}
+
+func (proj *Project) newBuiltin_require() *starlark.Builtin {
+	const doc = `
+    Builds additional dependencies of the calling target while the target
+    runs. Intended for targets that only learn what they depend on after
+    inspecting their inputs. The calling target waits for the required
+    targets to be built, and the required targets are treated as
+    dependencies of the calling target the next time it is checked for
+    changes. May only be called by a running target.
+
+    :param deps: the targets to build. Must be a label or target, or a
+                 sequence of labels or targets.
+
+    :returns: the required target, or a list of the required targets if
+              deps is a sequence.
+    `
+	return starlark.NewBuiltin("require", proj.starlark_builtin_require).WithDoc(doc)
+}
+
+func (proj *Project) starlark_builtin_require(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var deps starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "deps", &deps); err != nil {
 		return nil, err
 	}
 
-	val, err := proj.builtin_target(thread, fn, name, deps, sources, generates, function, default_, always, docs)
+	val, err := proj.builtin_require(thread, fn, deps)
 	if err != nil {
 		return nil, &starlark.EvalError{Msg: err.Error(), CallStack: thread.CallStack()}
 	}
@@ -259,7 +338,7 @@ func (proj *Project) newBuiltin_run() *starlark.Builtin {
 	const doc = `
     Builds a target.
 
-    :param label_or_target: the label or target to run.
+    :param label_or_target: the label or target to run. The label may be a pattern.
     :param always: True if all targets should be considered out-of-date.
     :param dry_run: True if the targets to run should be displayed but not run.
     :param callback: a callback that receives build events. If absent,
@@ -3,28 +3,36 @@ package main
 import (
 	"github.com/pgavlin/dawn"
 	"github.com/pgavlin/dawn/label"
+	"github.com/pgavlin/dawn/runner"
 )
 
 var (
 	buildJSON    string
 	buildDOT     string
+	buildLoadAll bool
 	buildOptions dawn.RunOptions
 )
 
 var buildCmd = newTargetCommand(&targetCommand{
 	Use:   "build",
-	Short: "Build a target",
-	Run: func(label *label.Label, args []string) error {
-		if err := work.loadProject(args, false, false); err != nil {
+	Short: "Build one or more targets",
+	RunLabels: func(labels []*label.Label, args []string) error {
+		// Only the packages that define the requested targets and their dependencies are
+		// loaded unless the DOT graph, which describes the entire project, was requested.
+		lazy := !buildLoadAll && buildDOT == ""
+		if err := work.loadProject(args, false, lazy, false); err != nil {
 			return err
 		}
-		return work.run(label, buildOptions)
+		return work.run(labels, buildOptions)
 	},
 })
 
 func init() {
 	buildCmd.Flags().BoolVarP(&buildOptions.Always, "always", "B", false, "consider all targets out-of-date")
 	buildCmd.Flags().BoolVarP(&buildOptions.DryRun, "dry-run", "n", false, "print the targets that would be built, but do not build them")
+	buildCmd.Flags().BoolVarP(&buildOptions.KeepGoing, "keep-going", "k", false, "build as many targets as possible after a failure")
+	buildCmd.Flags().StringVar(&buildOptions.Schedule, "schedule", runner.ScheduleCriticalPath, "the order in which ready targets are run, either critical-path or fifo")
 	buildCmd.Flags().StringVar(&buildJSON, "json", "", "write JSON build events to the given path")
 	buildCmd.Flags().StringVar(&buildDOT, "dot", "", "write a DOT graph of out-of-date targets to the given path")
+	buildCmd.Flags().BoolVar(&buildLoadAll, "load-all", false, "load every package in the project before building")
 }
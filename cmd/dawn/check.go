@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/pgavlin/dawn"
+	"github.com/pgavlin/dawn/label"
+	"github.com/pgavlin/dawn/runner"
+)
+
+var checkOptions dawn.RunOptions
+
+var checkHermeticityCmd = newTargetCommand(&targetCommand{
+	Use:   "check-hermeticity",
+	Short: "Report the undeclared files read or written by one or more targets",
+	Long: `Build one or more targets and report the undeclared files that they access.
+
+Each target is re-run, and the processes it starts using os.exec or sh.exec run
+in a temporary copy of the project that only contains the contents of the
+target's sources and the files generated by its dependencies. Every other file
+is replaced by an empty placeholder. Reading a placeholder or writing a file that
+the target does not generate is reported along with the target's position.
+
+Checking hermeticity requires Linux user namespaces, and undeclared reads are
+only detected on filesystems that record access times.`,
+	RunLabels: func(labels []*label.Label, args []string) error {
+		if err := work.loadProject(args, false, true, false); err != nil {
+			return err
+		}
+		return work.checkHermeticity(labels, checkOptions)
+	},
+})
+
+func init() {
+	checkHermeticityCmd.Flags().BoolVarP(&checkOptions.KeepGoing, "keep-going", "k", true, "check as many targets as possible after a failure")
+	checkHermeticityCmd.Flags().StringVar(&checkOptions.Schedule, "schedule", runner.ScheduleCriticalPath, "the order in which ready targets are run, either critical-path or fifo")
+	checkHermeticityCmd.Flags().StringVar(&buildJSON, "json", "", "write JSON build events to the given path")
+}
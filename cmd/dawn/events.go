@@ -70,6 +70,13 @@ func (e *lineRenderer) ModuleLoading(label *label.Label) {
 	e.print(label, "loading")
 }
 
+func (e *lineRenderer) PackagesDiscovered(packages, dirs int, elapsed time.Duration) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	fmt.Fprintf(e.stdout, "discovered %v packages in %v directories in %v\n", packages, dirs, elapsed.Round(time.Millisecond))
+}
+
 func (e *lineRenderer) ModuleLoaded(label *label.Label) {
 	e.print(label, "loaded")
 }
@@ -83,9 +90,9 @@ func (e *lineRenderer) LoadDone(err error) {
 	defer e.m.Unlock()
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to load project: %v", errMessage(err))
+		fmt.Fprintf(os.Stderr, "failed to load project: %v\n", errMessage(err))
 	} else {
-		fmt.Fprintf(os.Stdout, "project loaded")
+		fmt.Fprintf(os.Stdout, "project loaded\n")
 	}
 
 	if e.onLoaded != nil {
@@ -105,6 +112,22 @@ func (e *lineRenderer) TargetEvaluating(label *label.Label, reason string, diff
 	e.print(label, "evaluating...")
 }
 
+func (e *lineRenderer) TargetCacheHit(label *label.Label, remote bool) {
+	if remote {
+		e.print(label, "restored from remote cache")
+	} else {
+		e.print(label, "restored from cache")
+	}
+}
+
+func (e *lineRenderer) TargetCacheMiss(label *label.Label) {
+	e.print(label, "not cached")
+}
+
+func (e *lineRenderer) TargetRetrying(label *label.Label, attempt int, err error) {
+	e.printe(label, fmt.Sprintf("attempt %v failed, retrying: %v", attempt, errMessage(err)))
+}
+
 func (e *lineRenderer) TargetFailed(label *label.Label, err error) {
 	e.printe(label, fmt.Sprintf("failed: %v", errMessage(err)))
 }
@@ -118,9 +141,9 @@ func (e *lineRenderer) RunDone(err error) {
 	defer e.m.Unlock()
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "build failed: %v", errMessage(err))
+		fmt.Fprintf(os.Stderr, "build failed: %v\n", errMessage(err))
 	} else {
-		fmt.Fprintf(os.Stdout, "build succeeded")
+		fmt.Fprintf(os.Stdout, "build succeeded\n")
 	}
 }
 
@@ -128,6 +151,17 @@ func (e *lineRenderer) FileChanged(label *label.Label) {
 	e.print(label, "changed")
 }
 
+func (e *lineRenderer) WatchPolling(interval time.Duration, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if err != nil {
+		fmt.Fprintf(e.stderr, "watching files failed (%v); polling for changes every %v\n", errMessage(err), interval)
+	} else {
+		fmt.Fprintf(e.stdout, "polling for changes every %v\n", interval)
+	}
+}
+
 func (e *lineRenderer) print(label *label.Label, message string) {
 	e.fprint(e.stdout, label, message)
 }
@@ -180,6 +214,10 @@ func (e *dotRenderer) ModuleLoading(label *label.Label) {
 	e.next.ModuleLoading(label)
 }
 
+func (e *dotRenderer) PackagesDiscovered(packages, dirs int, elapsed time.Duration) {
+	e.next.PackagesDiscovered(packages, dirs, elapsed)
+}
+
 func (e *dotRenderer) ModuleLoaded(label *label.Label) {
 	e.next.ModuleLoaded(label)
 }
@@ -210,6 +248,18 @@ func (e *dotRenderer) TargetEvaluating(label *label.Label, reason string, diff d
 	e.next.TargetEvaluating(label, reason, diff)
 }
 
+func (e *dotRenderer) TargetCacheHit(label *label.Label, remote bool) {
+	e.next.TargetCacheHit(label, remote)
+}
+
+func (e *dotRenderer) TargetCacheMiss(label *label.Label) {
+	e.next.TargetCacheMiss(label)
+}
+
+func (e *dotRenderer) TargetRetrying(label *label.Label, attempt int, err error) {
+	e.next.TargetRetrying(label, attempt, err)
+}
+
 func (e *dotRenderer) TargetFailed(label *label.Label, err error) {
 	e.decorateNode(label, func(n *node) { n.status = "failed" })
 	e.next.TargetFailed(label, err)
@@ -229,6 +279,10 @@ func (e *dotRenderer) FileChanged(label *label.Label) {
 	e.next.FileChanged(label)
 }
 
+func (e *dotRenderer) WatchPolling(interval time.Duration, err error) {
+	e.next.WatchPolling(interval, err)
+}
+
 func (e *dotRenderer) decorateNode(label *label.Label, decorator func(n *node)) {
 	e.m.Lock()
 	defer e.m.Unlock()
@@ -282,6 +336,11 @@ func (e *jsonRenderer) ModuleLoading(label *label.Label) {
 	e.next.ModuleLoading(label)
 }
 
+func (e *jsonRenderer) PackagesDiscovered(packages, dirs int, elapsed time.Duration) {
+	e.event("PackagesDiscovered", nil, "packages", packages, "dirs", dirs, "elapsed", elapsed.Seconds())
+	e.next.PackagesDiscovered(packages, dirs, elapsed)
+}
+
 func (e *jsonRenderer) ModuleLoaded(label *label.Label) {
 	e.event("ModuleLoaded", label)
 	e.next.ModuleLoaded(label)
@@ -320,6 +379,21 @@ func (e *jsonRenderer) TargetEvaluating(label *label.Label, reason string, diff
 	e.next.TargetEvaluating(label, reason, diff)
 }
 
+func (e *jsonRenderer) TargetCacheHit(label *label.Label, remote bool) {
+	e.event("TargetCacheHit", label, "remote", remote)
+	e.next.TargetCacheHit(label, remote)
+}
+
+func (e *jsonRenderer) TargetCacheMiss(label *label.Label) {
+	e.event("TargetCacheMiss", label)
+	e.next.TargetCacheMiss(label)
+}
+
+func (e *jsonRenderer) TargetRetrying(label *label.Label, attempt int, err error) {
+	e.event("TargetRetrying", label, "attempt", attempt, "err", errMessage(err))
+	e.next.TargetRetrying(label, attempt, err)
+}
+
 func (e *jsonRenderer) TargetFailed(label *label.Label, err error) {
 	e.event("TargetFailed", label, "err", errMessage(err))
 	e.next.TargetFailed(label, err)
@@ -340,6 +414,15 @@ func (e *jsonRenderer) FileChanged(label *label.Label) {
 	e.next.FileChanged(label)
 }
 
+func (e *jsonRenderer) WatchPolling(interval time.Duration, err error) {
+	if err != nil {
+		e.event("WatchPolling", nil, "interval", interval.Seconds(), "err", errMessage(err))
+	} else {
+		e.event("WatchPolling", nil, "interval", interval.Seconds())
+	}
+	e.next.WatchPolling(interval, err)
+}
+
 func (e *jsonRenderer) event(kind string, label *label.Label, pairs ...interface{}) {
 	e.m.Lock()
 	defer e.m.Unlock()
@@ -537,9 +620,7 @@ func (e *statusRenderer) render(now time.Time, closed bool) {
 	if !closed && e.evaluating.head != nil {
 		suffix := ""
 		if e.running {
-			if _, waiting := e.work.project.Metrics(); waiting != 0 {
-				suffix = fmt.Sprintf(" (%v waiting)", waiting)
-			}
+			suffix = metricsSuffix(e.work.project.Metrics())
 		}
 		e.line(e.stats.line() + suffix)
 	}
@@ -796,6 +877,14 @@ func (e *statusRenderer) RequirementLoadFailed(label *label.Label, version strin
 	e.targetDone(label, color.RedString("failed: %v", errMessage(err)), true, true)
 }
 
+func (e *statusRenderer) PackagesDiscovered(packages, dirs int, elapsed time.Duration) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.statusLine = color.WhiteString("discovered %v packages in %v directories in %v", packages, dirs, elapsed.Round(time.Millisecond))
+	e.dirty = true
+}
+
 func (e *statusRenderer) ModuleLoading(label *label.Label) {
 	e.targetStarted(label, "", nil, "loading...")
 }
@@ -868,6 +957,33 @@ func (e *statusRenderer) targetDone(label *label.Label, message string, changed,
 	e.dirty = true
 }
 
+func (e *statusRenderer) TargetCacheHit(label *label.Label, remote bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if t := e.targets[label.String()]; t != nil {
+		if remote {
+			t.setStatus("restored from remote cache")
+		} else {
+			t.setStatus("restored from cache")
+		}
+		e.dirty = true
+	}
+}
+
+func (e *statusRenderer) TargetCacheMiss(label *label.Label) {
+}
+
+func (e *statusRenderer) TargetRetrying(label *label.Label, attempt int, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if t := e.targets[label.String()]; t != nil {
+		t.setStatus(color.YellowString("attempt %v failed, retrying: %v", attempt, errMessage(err)))
+		e.dirty = true
+	}
+}
+
 func (e *statusRenderer) TargetFailed(label *label.Label, err error) {
 	e.targetDone(label, color.RedString("failed: %v", errMessage(err)), true, true)
 }
@@ -887,6 +1003,18 @@ func (e *statusRenderer) FileChanged(label *label.Label) {
 	e.dirty = true
 }
 
+func (e *statusRenderer) WatchPolling(interval time.Duration, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if err != nil {
+		e.lines = append(e.lines, color.YellowString("watching files failed (%v); polling for changes every %v", errMessage(err), interval))
+	} else {
+		e.lines = append(e.lines, fmt.Sprintf("polling for changes every %v", interval))
+	}
+	e.dirty = true
+}
+
 func (e *statusRenderer) Close() error {
 	e.ticker.Stop()
 	e.render(time.Now(), true)
@@ -977,9 +1105,24 @@ func errMessage(err error) string {
 		return ""
 	}
 
+	var runErr *dawn.RunError
 	var evalErr *starlark.EvalError
 	var cdErr *runner.CyclicDependencyError
 	switch {
+	case errors.As(err, &runErr):
+		var b strings.Builder
+		if len(runErr.Failures) == 1 {
+			b.WriteString("1 target failed:")
+		} else {
+			fmt.Fprintf(&b, "%v targets failed:", len(runErr.Failures))
+		}
+		for _, f := range runErr.Failures {
+			fmt.Fprintf(&b, "\n\n[%v] %v", f.Label, errMessage(f.Err))
+			if len(f.Blocked) != 0 {
+				fmt.Fprintf(&b, "\n  blocked: %v", strings.Join(f.Blocked, ", "))
+			}
+		}
+		return b.String()
 	case errors.As(err, &cdErr):
 		return cdErr.Trace()
 	case errors.As(err, &evalErr):
@@ -1003,3 +1146,21 @@ func (r resolveEvents) ProjectLoaded(req project.RequirementConfig) {
 func (r resolveEvents) ProjectLoadFailed(req project.RequirementConfig, err error) {
 	r.events.RequirementLoadFailed(&label.Label{Kind: "project", Project: req.Path}, req.Version, err)
 }
+
+// metricsSuffix renders the number of waiting targets and the usage of any busy resource pools
+// other than the default pool.
+func metricsSuffix(metrics runner.Metrics) string {
+	var parts []string
+	if metrics.Waiting != 0 {
+		parts = append(parts, fmt.Sprintf("%v waiting", metrics.Waiting))
+	}
+	for _, p := range metrics.Pools {
+		if p.Name != runner.DefaultPool && p.InUse != 0 {
+			parts = append(parts, fmt.Sprintf("%v: %v/%v", p.Name, p.InUse, p.Capacity))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
@@ -8,7 +8,7 @@ var gcCmd = &cobra.Command{
 	Args:         cobra.NoArgs,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := work.loadProject(args, true, false); err != nil {
+		if err := work.loadProject(args, true, false, false); err != nil {
 			return err
 		}
 		return work.project.GC()
@@ -110,7 +110,7 @@ var graphCmd = &cobra.Command{
 	Args:         cobra.NoArgs,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := work.loadProject(args, true, true); err != nil {
+		if err := work.loadProject(args, true, false, true); err != nil {
 			return err
 		}
 		return errors.Join(work.renderer.Close(), work.graph.dot(os.Stdout, func(_ *node) bool { return true }))
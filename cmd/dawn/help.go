@@ -26,7 +26,7 @@ var helpCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		if err := work.loadProject(args, true, false); err != nil {
+		if err := work.loadProject(args, true, false, false); err != nil {
 			return err
 		}
 
@@ -102,7 +102,7 @@ var flagsCmd = &cobra.Command{
 	Short: "List available flags",
 	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := work.loadProject(args, true, listJSON); err != nil {
+		if err := work.loadProject(args, true, false, listJSON); err != nil {
 			return err
 		}
 		return errors.Join(work.renderer.Close(), printFlagList(work.project.Flags()))
@@ -114,7 +114,7 @@ var targetsCmd = &cobra.Command{
 	Short: "List available targets",
 	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := work.loadProject(args, true, listJSON); err != nil {
+		if err := work.loadProject(args, true, false, listJSON); err != nil {
 			return err
 		}
 		return errors.Join(work.renderer.Close(), printTargetList(work.project.Targets()))
@@ -125,7 +125,7 @@ var dependsCmd = newTargetCommand(&targetCommand{
 	Use:   "depends",
 	Short: "List a target's transitive dependencies",
 	Run: func(label *label.Label, args []string) error {
-		if err := work.loadProject(args, true, listJSON); err != nil {
+		if err := work.loadProject(args, true, false, listJSON); err != nil {
 			return err
 		}
 		if err := work.renderer.Close(); err != nil {
@@ -143,7 +143,7 @@ var whatDependsCmd = newTargetCommand(&targetCommand{
 	Use:   "what-depends",
 	Short: "List a target's transitive dependents",
 	Run: func(label *label.Label, args []string) error {
-		if err := work.loadProject(args, true, listJSON); err != nil {
+		if err := work.loadProject(args, true, false, listJSON); err != nil {
 			return err
 		}
 		if err := work.renderer.Close(); err != nil {
@@ -161,7 +161,7 @@ var sourcesCmd = newTargetCommand(&targetCommand{
 	Use:   "sources",
 	Short: "List a target's sources",
 	Run: func(label *label.Label, args []string) error {
-		if err := work.loadProject(args, true, listJSON); err != nil {
+		if err := work.loadProject(args, true, false, listJSON); err != nil {
 			return err
 		}
 		if err := work.renderer.Close(); err != nil {
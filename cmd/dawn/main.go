@@ -3,17 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
-
-	"github.com/pgavlin/starlark-go/starlark"
 )
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		if serr, ok := err.(*starlark.EvalError); ok {
-			fmt.Fprintln(os.Stderr, serr.Backtrace())
-		} else {
-			fmt.Fprintln(os.Stderr, err)
-		}
+		fmt.Fprintln(os.Stderr, errMessage(err))
 		os.Exit(1)
 	}
 }
@@ -8,7 +8,7 @@ var replCmd = newTargetCommand(&targetCommand{
 	Use:   "repl",
 	Short: "Launch the REPL",
 	Run: func(label *label.Label, args []string) error {
-		if err := work.loadProject(args, replIndexOnly, false); err != nil {
+		if err := work.loadProject(args, replIndexOnly, false, false); err != nil {
 			return err
 		}
 		return work.repl(label)
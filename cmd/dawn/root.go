@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/pgavlin/dawn/cmd/dawn/internal/term"
+	"github.com/pgavlin/dawn/runner"
 	"github.com/pgavlin/dawn/util"
 	"github.com/spf13/cobra"
 )
@@ -52,10 +53,15 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&work.reindex, "reindex", "r", false, "refresh the project's index")
 	rootCmd.PersistentFlags().BoolVarP(&work.verbose, "verbose", "V", false, "print verbose build output (incl. target stdout)")
 	rootCmd.PersistentFlags().BoolVarP(&work.diff, "diff", "d", false, "print the reasons that targets are built")
+	rootCmd.PersistentFlags().StringVar(&work.remoteCache, "remote-cache", "", "the URL of a remote HTTP cache (overrides dawn.toml)")
+	rootCmd.PersistentFlags().StringVar(&work.remoteCacheMode, "remote-cache-mode", "", "the remote cache mode, either read-only or write-through (overrides dawn.toml)")
 
 	rootCmd.Flags().BoolVarP(&buildOptions.Always, "always", "B", false, "consider all targets out-of-date")
 	rootCmd.Flags().BoolVarP(&buildOptions.DryRun, "dry-run", "n", false, "print the targets that would be built, but do not build them")
+	rootCmd.Flags().BoolVarP(&buildOptions.KeepGoing, "keep-going", "k", false, "build as many targets as possible after a failure")
+	rootCmd.Flags().StringVar(&buildOptions.Schedule, "schedule", runner.ScheduleCriticalPath, "the order in which ready targets are run, either critical-path or fifo")
 	rootCmd.Flags().StringVar(&buildDOT, "dot", "", "write a DOT graph of out-of-date targets to the given path")
+	rootCmd.Flags().BoolVar(&buildLoadAll, "load-all", false, "load every package in the project before building")
 	rootCmd.Flags().StringVar(&buildJSON, "json", "", "write JSON build events to the given path")
 
 	rootCmd.PersistentFlags().SetInterspersed(false)
@@ -64,6 +70,7 @@ func init() {
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(buildCmd)
 	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(checkHermeticityCmd)
 	rootCmd.AddCommand(replCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(gcCmd)
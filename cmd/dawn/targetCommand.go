@@ -10,11 +10,12 @@ type targetCommand struct {
 	Short             string
 	Long              string
 	Run               func(label *label.Label, args []string) error
+	RunLabels         func(labels []*label.Label, args []string) error
 	ValidArgsFunction func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective)
 }
 
 func newTargetCommand(cmd *targetCommand) *cobra.Command {
-	run := cmd.Run
+	run, runLabels := cmd.Run, cmd.RunLabels
 	cobraCmd := &cobra.Command{
 		Use:          cmd.Use,
 		Short:        cmd.Short,
@@ -22,6 +23,14 @@ func newTargetCommand(cmd *targetCommand) *cobra.Command {
 		Args:         cobra.ArbitraryArgs,
 		SilenceUsage: true,
 		RunE: func(_ *cobra.Command, args []string) error {
+			if runLabels != nil {
+				labels, args, err := work.labelArgs(args)
+				if err != nil {
+					return err
+				}
+				return runLabels(labels, args)
+			}
+
 			label, args, err := work.labelArg(args)
 			if err != nil {
 				return err
@@ -1,18 +1,25 @@
 package main
 
-import "github.com/pgavlin/dawn/label"
+import (
+	"github.com/pgavlin/dawn"
+	"github.com/pgavlin/dawn/label"
+)
+
+var watchOptions dawn.WatchOptions
 
 var watchCmd = newTargetCommand(&targetCommand{
 	Use:   "watch",
-	Short: "Watch for changes and rebuild a target as necessary",
-	Run: func(label *label.Label, args []string) error {
-		if err := work.loadProject(args, false, false); err != nil {
+	Short: "Watch for changes and rebuild one or more targets as necessary",
+	RunLabels: func(labels []*label.Label, args []string) error {
+		if err := work.loadProject(args, false, false, false); err != nil {
 			return err
 		}
-		return work.watch(label)
+		return work.watch(labels, watchOptions)
 	},
 })
 
 func init() {
 	watchCmd.Flags().StringVar(&buildJSON, "json", "", "write JSON build events to the given path")
+	watchCmd.Flags().BoolVar(&watchOptions.Poll, "poll", false, "poll for changes instead of using the platform's file watcher")
+	watchCmd.Flags().DurationVar(&watchOptions.PollInterval, "poll-interval", dawn.DefaultPollInterval, "the interval at which to poll for changes")
 }
@@ -31,6 +31,9 @@ type workspace struct {
 	verbose    bool
 	diff       bool
 
+	remoteCache     string
+	remoteCacheMode string
+
 	context  context.Context
 	project  *dawn.Project
 	graph    graph
@@ -87,60 +90,83 @@ func (w *workspace) init() error {
 
 func (w *workspace) labelArg(args []string) (*label.Label, []string, error) {
 	rawlabel := ":default"
-	switch len(args) {
-	case 0:
-		// OK
-	default:
-		if !strings.HasPrefix(args[0], "--") {
-			rawlabel, args = args[0], args[1:]
-
-			// Unless it is obviously a label, see if the arg is interpretable as a path to a source file.
-			if !strings.HasPrefix(rawlabel, "//") && !strings.ContainsRune(rawlabel, ':') {
-				p, err := filepath.Abs(rawlabel)
-				if err != nil {
-					return nil, nil, fmt.Errorf("computing path: %w", err)
-				}
-				stat, err := os.Lstat(p)
-				if !os.IsNotExist(err) {
-					if err != nil {
-						return nil, nil, fmt.Errorf("stating file: %w", err)
-					}
-					if !stat.IsDir() {
-						modulePath, sourceFile := path.Split(filepath.ToSlash(rawlabel))
-						rawlabel = fmt.Sprintf("source:%v:%v", modulePath, sourceFile)
-					}
-				}
-			}
-		}
+	if len(args) != 0 && !strings.HasPrefix(args[0], "--") {
+		rawlabel, args = args[0], args[1:]
 	}
 
-	label, err := label.Parse(rawlabel)
+	label, err := w.parseLabel(rawlabel, label.Parse)
 	if err != nil {
 		return nil, nil, err
 	}
-	label, err = label.RelativeTo(w.package_)
+	return label, args, nil
+}
+
+// labelArgs consumes the leading arguments that are not flags as labels. Each label may be a
+// pattern. If there are no such arguments, the returned labels hold only :default.
+func (w *workspace) labelArgs(args []string) ([]*label.Label, []string, error) {
+	var labels []*label.Label
+	for len(args) != 0 && !strings.HasPrefix(args[0], "--") {
+		label, err := w.parseLabel(args[0], label.ParsePattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		labels, args = append(labels, label), args[1:]
+	}
+	if len(labels) == 0 {
+		label, err := w.parseLabel(":default", label.Parse)
+		if err != nil {
+			return nil, nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, args, nil
+}
+
+func (w *workspace) parseLabel(rawlabel string, parse func(string) (*label.Label, error)) (*label.Label, error) {
+	// Unless it is obviously a label, see if the arg is interpretable as a path to a source file.
+	if !strings.HasPrefix(rawlabel, "//") && !strings.ContainsRune(rawlabel, ':') && !strings.HasSuffix(rawlabel, "...") {
+		p, err := filepath.Abs(rawlabel)
+		if err != nil {
+			return nil, fmt.Errorf("computing path: %w", err)
+		}
+		stat, err := os.Lstat(p)
+		if !os.IsNotExist(err) {
+			if err != nil {
+				return nil, fmt.Errorf("stating file: %w", err)
+			}
+			if !stat.IsDir() {
+				modulePath, sourceFile := path.Split(filepath.ToSlash(rawlabel))
+				rawlabel = fmt.Sprintf("source:%v:%v", modulePath, sourceFile)
+			}
+		}
+	}
+
+	label, err := parse(rawlabel)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return label, args, nil
+	return label.RelativeTo(w.package_)
 }
 
 func (w *workspace) validLabels(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
-	targets, err := dawn.Targets(w.root)
-	if err != nil {
+	// Prefer the project's index. If the index is stale, the project is reloaded.
+	if err := w.loadProject(nil, true, false, true); err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	if err := w.renderer.Close(); err != nil {
 		return nil, cobra.ShellCompDirectiveError
 	}
 
-	labels := slices.Collect(fxs.FMap(targets, func(t dawn.TargetSummary) (string, bool) {
-		if dawn.IsTarget(t.Label) {
-			return fmt.Sprintf("%v\t%s", t.Label, t.Summary), true
+	labels := slices.Collect(fxs.FMap(w.project.Targets(), func(t dawn.Target) (string, bool) {
+		if dawn.IsTarget(t.Label()) {
+			return fmt.Sprintf("%v\t%s", t.Label(), dawn.DocSummary(t)), true
 		}
 		return "", false
 	}))
 	return labels, cobra.ShellCompDirectiveDefault
 }
 
-func (w *workspace) loadProject(args []string, index, quiet bool) error {
+func (w *workspace) loadProject(args []string, index, lazy, quiet bool) error {
 	rendered := make(chan bool)
 	firstLoad := true
 
@@ -170,7 +196,10 @@ func (w *workspace) loadProject(args []string, index, quiet bool) error {
 			"os":   starlark_os.Module,
 			"sh":   starlark_sh.Module,
 		},
-		PreferIndex: !w.reindex && index,
+		PreferIndex:     !w.reindex && index,
+		Lazy:            lazy,
+		RemoteCache:     w.remoteCache,
+		RemoteCacheMode: w.remoteCacheMode,
 	}
 	project, err := dawn.Load(w.context, w.root, options)
 	if err != nil {
@@ -253,12 +282,50 @@ func (w *workspace) labelOrNearestDefault(l *label.Label) *label.Label {
 	return original
 }
 
-func (w *workspace) run(label *label.Label, opts dawn.RunOptions) error {
-	err := w.project.Run(w.context, w.labelOrNearestDefault(label), &opts)
+func (w *workspace) targetLabels(labels []*label.Label) []*label.Label {
+	targets := make([]*label.Label, len(labels))
+	for i, l := range labels {
+		if l.IsPattern() {
+			targets[i] = l
+		} else {
+			targets[i] = w.labelOrNearestDefault(l)
+		}
+	}
+	return targets
+}
+
+func (w *workspace) run(labels []*label.Label, opts dawn.RunOptions) error {
+	err := w.project.Run(w.context, w.targetLabels(labels), &opts)
 	return errors.Join(w.renderer.Close(), err)
 }
 
-func (w *workspace) watch(label *label.Label) error {
-	err := w.project.Watch(w.context, w.labelOrNearestDefault(label))
+func (w *workspace) checkHermeticity(labels []*label.Label, opts dawn.RunOptions) error {
+	reports, err := w.project.CheckHermeticity(w.context, w.targetLabels(labels), &opts)
+	err = errors.Join(w.renderer.Close(), err)
+
+	for _, r := range reports {
+		prefix := r.Target.Label().String()
+		if pos := w.targetRelPos(r.Target); pos != "" {
+			prefix = fmt.Sprintf("%v: %v", pos, prefix)
+		}
+		for _, path := range r.Reads {
+			fmt.Printf("%v read undeclared file %v\n", prefix, path)
+		}
+		for _, path := range r.Writes {
+			fmt.Printf("%v wrote undeclared file %v\n", prefix, path)
+		}
+	}
+	if err == nil && len(reports) != 0 {
+		if len(reports) == 1 {
+			err = errors.New("1 target accessed undeclared files")
+		} else {
+			err = fmt.Errorf("%v targets accessed undeclared files", len(reports))
+		}
+	}
+	return err
+}
+
+func (w *workspace) watch(labels []*label.Label, opts dawn.WatchOptions) error {
+	err := w.project.WatchWithOptions(w.context, w.targetLabels(labels), &opts)
 	return errors.Join(w.renderer.Close(), err)
 }
@@ -0,0 +1,75 @@
+package dawn
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseDepfile parses a Makefile-style dependency file, e.g. one written by a C compiler's -MD
+// option, and returns the prerequisites of each of its rules. Line continuations, comments, and
+// the escapes written by common compilers (\ for spaces, \# for number signs, and $$ for dollar
+// signs) are supported.
+func parseDepfile(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.NewReplacer("\\\r\n", " ", "\\\n", " ").Replace(string(data))
+
+	var prereqs []string
+	for i, line := range strings.Split(text, "\n") {
+		words, colon := splitDepfileLine(strings.TrimSuffix(line, "\r"))
+		if colon < 0 {
+			if len(words) != 0 {
+				return nil, fmt.Errorf("line %v: expected a rule", i+1)
+			}
+			continue
+		}
+		prereqs = append(prereqs, words[colon:]...)
+	}
+	return prereqs, nil
+}
+
+// splitDepfileLine splits a line of a dependency file into words. If the line contains a rule,
+// splitDepfileLine also returns the index of the rule's first prerequisite; otherwise it returns
+// -1.
+func splitDepfileLine(line string) (words []string, colon int) {
+	var word strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			words, inWord = append(words, word.String()), false
+			word.Reset()
+		}
+	}
+
+	colon = -1
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '\t' || line[i+1] == '#'):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case c == '$' && i+1 < len(line) && line[i+1] == '$':
+			i++
+			word.WriteByte('$')
+			inWord = true
+		case c == '#':
+			flush()
+			return words, colon
+		case c == ' ' || c == '\t':
+			flush()
+		case c == ':' && colon < 0 && (i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t'):
+			// A colon that is not followed by whitespace is part of a path, e.g. C:\foo.h.
+			flush()
+			colon = len(words)
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush()
+	return words, colon
+}
@@ -0,0 +1,65 @@
+package dawn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDepfile(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name:     "simple",
+			text:     "main.o: main.c main.h\n",
+			expected: []string{"main.c", "main.h"},
+		},
+		{
+			name:     "continuations",
+			text:     "main.o: main.c \\\n  include/a.h \\\r\n  /usr/include/stdio.h\n",
+			expected: []string{"main.c", "include/a.h", "/usr/include/stdio.h"},
+		},
+		{
+			name:     "phony targets",
+			text:     "main.o: main.c main.h\n\nmain.h:\n",
+			expected: []string{"main.c", "main.h"},
+		},
+		{
+			name:     "escapes",
+			text:     "main.o: my\\ file.h hash\\#.h dollar$$.h\n",
+			expected: []string{"my file.h", "hash#.h", "dollar$.h"},
+		},
+		{
+			name:     "comments",
+			text:     "# generated\nmain.o: main.c # main.h\n",
+			expected: []string{"main.c"},
+		},
+		{
+			name:     "drive letters",
+			text:     "main.o : C:\\src\\main.c\n",
+			expected: []string{"C:\\src\\main.c"},
+		},
+		{
+			name:     "multiple targets",
+			text:     "main.o main.d: main.c",
+			expected: []string{"main.c"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			prereqs, err := parseDepfile(strings.NewReader(c.text))
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, prereqs)
+		})
+	}
+
+	_, err := parseDepfile(strings.NewReader("main.o main.c\n"))
+	assert.EqualError(t, err, "line 1: expected a rule")
+}
@@ -3,6 +3,7 @@ package dawn
 import (
 	"errors"
 	"slices"
+	"time"
 
 	"github.com/pgavlin/dawn/diff"
 	"github.com/pgavlin/dawn/label"
@@ -23,6 +24,9 @@ type Events interface {
 	// RequirementLoadFailed is called when a referenced project fails to load.
 	RequirementLoadFailed(label *label.Label, version string, err error)
 
+	// PackagesDiscovered is called when the search for a project's packages finishes. The search
+	// found the given number of packages in the given number of directories.
+	PackagesDiscovered(packages, dirs int, elapsed time.Duration)
 	// ModuleLoading is called when the given module begins loading.
 	ModuleLoading(label *label.Label)
 	// ModuleLoaded is called when the given module finishes loading successfully.
@@ -38,6 +42,14 @@ type Events interface {
 	TargetWaiting(label *label.Label, dependencies []string)
 	// TargetEvaluating is called when a target begins executing.
 	TargetEvaluating(label *label.Label, reason string, diff diff.ValueDiff)
+	// TargetCacheHit is called when a target's results are restored from the action cache. The
+	// remote parameter is true if the results were fetched from a remote cache.
+	TargetCacheHit(label *label.Label, remote bool)
+	// TargetCacheMiss is called when the action cache does not hold results for a target.
+	TargetCacheMiss(label *label.Label)
+	// TargetRetrying is called when an attempt to evaluate a target fails and the target will be
+	// retried. Attempts are numbered from 1.
+	TargetRetrying(label *label.Label, attempt int, err error)
 	// TargetFailed is called when a target fails.
 	TargetFailed(label *label.Label, err error)
 	// TargetSucceeded is called when a target succeeds.
@@ -47,6 +59,10 @@ type Events interface {
 
 	// FileChanged is called during Watch when a file changes and triggers a reload.
 	FileChanged(label *label.Label)
+	// WatchPolling is called when Watch begins polling for changes at the given interval. If
+	// polling was chosen because the platform's file watcher could not be started, err holds the
+	// reason.
+	WatchPolling(interval time.Duration, err error)
 }
 
 type discardEventsT int
@@ -58,6 +74,7 @@ func (discardEventsT) Print(label *label.Label, line string)
 func (discardEventsT) RequirementLoading(label *label.Label, version string)                   {}
 func (discardEventsT) RequirementLoaded(label *label.Label, version string)                    {}
 func (discardEventsT) RequirementLoadFailed(label *label.Label, version string, err error)     {}
+func (discardEventsT) PackagesDiscovered(packages, dirs int, elapsed time.Duration)            {}
 func (discardEventsT) ModuleLoading(label *label.Label)                                        {}
 func (discardEventsT) ModuleLoaded(label *label.Label)                                         {}
 func (discardEventsT) ModuleLoadFailed(label *label.Label, err error)                          {}
@@ -65,10 +82,14 @@ func (discardEventsT) LoadDone(err error)
 func (discardEventsT) TargetUpToDate(label *label.Label)                                       {}
 func (discardEventsT) TargetWaiting(label *label.Label, dependencies []string)                 {}
 func (discardEventsT) TargetEvaluating(label *label.Label, reason string, diff diff.ValueDiff) {}
+func (discardEventsT) TargetCacheHit(label *label.Label, remote bool)                          {}
+func (discardEventsT) TargetCacheMiss(label *label.Label)                                      {}
+func (discardEventsT) TargetRetrying(label *label.Label, attempt int, err error)               {}
 func (discardEventsT) TargetFailed(label *label.Label, err error)                              {}
 func (discardEventsT) TargetSucceeded(label *label.Label, changed bool)                        {}
 func (discardEventsT) RunDone(err error)                                                       {}
 func (discardEventsT) FileChanged(label *label.Label)                                          {}
+func (discardEventsT) WatchPolling(interval time.Duration, err error)                          {}
 
 type runEvents struct {
 	c        chan starlark.Value
@@ -90,11 +111,13 @@ func (e *runEvents) process(thread *starlark.Thread) error {
 func (*runEvents) RequirementLoading(label *label.Label, version string)               {}
 func (*runEvents) RequirementLoaded(label *label.Label, version string)                {}
 func (*runEvents) RequirementLoadFailed(label *label.Label, version string, err error) {}
+func (*runEvents) PackagesDiscovered(packages, dirs int, elapsed time.Duration)        {}
 func (*runEvents) ModuleLoading(label *label.Label)                                    {}
 func (*runEvents) ModuleLoaded(label *label.Label)                                     {}
 func (*runEvents) ModuleLoadFailed(label *label.Label, err error)                      {}
 func (*runEvents) LoadDone(err error)                                                  {}
 func (*runEvents) FileChanged(label *label.Label)                                      {}
+func (*runEvents) WatchPolling(interval time.Duration, err error)                      {}
 
 func (e *runEvents) Print(label *label.Label, line string) {
 	e.c <- starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
@@ -133,6 +156,30 @@ func (e *runEvents) TargetEvaluating(label *label.Label, reason string, diff dif
 	})
 }
 
+func (e *runEvents) TargetCacheHit(label *label.Label, remote bool) {
+	e.c <- starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"kind":   starlark.String("TargetCacheHit"),
+		"label":  starlark.String(label.String()),
+		"remote": starlark.Bool(remote),
+	})
+}
+
+func (e *runEvents) TargetCacheMiss(label *label.Label) {
+	e.c <- starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"kind":  starlark.String("TargetCacheMiss"),
+		"label": starlark.String(label.String()),
+	})
+}
+
+func (e *runEvents) TargetRetrying(label *label.Label, attempt int, err error) {
+	e.c <- starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"kind":    starlark.String("TargetRetrying"),
+		"label":   starlark.String(label.String()),
+		"attempt": starlark.MakeInt(attempt),
+		"err":     starlark.String(err.Error()),
+	})
+}
+
 func (e *runEvents) TargetFailed(label *label.Label, err error) {
 	e.c <- starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
 		"kind":  starlark.String("TargetUpToDate"),
@@ -3,17 +3,25 @@ package dawn
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pgavlin/dawn/diff"
+	"github.com/pgavlin/dawn/internal/sandbox"
 	"github.com/pgavlin/dawn/label"
 	"github.com/pgavlin/dawn/pickle"
+	"github.com/pgavlin/dawn/runner"
 	"github.com/pgavlin/dawn/util"
 	fxs "github.com/pgavlin/fx/v2/slices"
 	"github.com/pgavlin/starlark-go/starlark"
@@ -25,22 +33,43 @@ import (
 //
 // A function is considered out-of-date if its environment--the globals, default parameter
 // values, and free variables references by the function--has changed with respect to its
-// last execution.
+// last execution, if any of its external inputs--the environment variables and tools it
+// declares--have changed since its last execution, if any of the sources listed in its depfile
+// after its last execution have changed, or if any of the files it generates have been modified
+// or removed since its last execution. The targets a function requires while it runs are treated
+// as dependencies of the function the next time it is checked.
+//
+// After a function runs, the files it generates are hashed. If the function generates files
+// and none of their contents changed, the function is not considered to have changed, and its
+// dependents need not be re-run.
 type function struct {
 	proj   *Project
 	module *module
 	label  *label.Label
 
 	always     bool
+	service    bool
+	sandbox    bool
+	resources  map[string]int
+	timeout    time.Duration
+	retries    int
 	targetInfo targetInfo
 	deps       []string
 	sources    []string
 	gens       []string
+	env        []string
+	tools      []string
+	depfile    string
 	docs       string
 	pos        *syntax.Position
 	function   starlark.Callable
 	oldEnv     starlark.Value
 	newEnv     starlark.Value
+	outputs    map[string]string
+	inputs     map[string]string
+	newInputs  map[string]string
+	discovered map[string]string
+	required   map[string]string
 
 	out *lineWriter
 }
@@ -72,6 +101,10 @@ func (f *function) Attr(name string) (starlark.Value, error) {
 		return f.label, nil
 	case "always":
 		return starlark.Bool(f.always), nil
+	case "service":
+		return starlark.Bool(f.service), nil
+	case "sandbox":
+		return starlark.Bool(f.sandbox || f.proj.sandbox), nil
 	case "function":
 		return f.function, nil
 	case "dependencies":
@@ -80,6 +113,14 @@ func (f *function) Attr(name string) (starlark.Value, error) {
 		return util.StringList(f.sources).List(), nil
 	case "generates":
 		return util.StringList(f.gens).List(), nil
+	case "resources":
+		resources := starlark.NewDict(len(f.resources))
+		for _, pool := range slices.Sorted(maps.Keys(f.resources)) {
+			if err := resources.SetKey(starlark.String(pool), starlark.MakeInt(f.resources[pool])); err != nil {
+				return nil, err
+			}
+		}
+		return resources, nil
 	case "position":
 		if f.pos != nil {
 			return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
@@ -95,7 +136,7 @@ func (f *function) Attr(name string) (starlark.Value, error) {
 }
 
 func (f *function) AttrNames() []string {
-	return []string{"label", "always", "function", "dependencies", "generates", "position", "sources"}
+	return []string{"label", "always", "function", "dependencies", "generates", "position", "resources", "sandbox", "service", "sources"}
 }
 
 func (f *function) Project() *Project {
@@ -123,6 +164,7 @@ func (f *function) info() targetInfo {
 }
 
 var functionEnvKeys = []starlark.String{
+	"source",
 	"names",
 	"constant values",
 	"predeclared values",
@@ -169,26 +211,94 @@ func (f *function) diffEnv() (bool, string, diff.ValueDiff, error) {
 		return string(k), bool(md.Has(k))
 	}))
 
-	var reason string
+	return false, joinReasons(reasons) + " changed", d, nil
+}
+
+// joinReasons joins the given reasons into a single English list.
+func joinReasons(reasons []string) string {
 	switch len(reasons) {
 	case 1:
-		reason = reasons[0]
+		return reasons[0]
 	case 2:
-		reason = reasons[0] + " and " + reasons[1]
+		return reasons[0] + " and " + reasons[1]
 	default:
-		reason = strings.Join(reasons[:len(reasons)-1], ", ") + ", and " + reasons[len(reasons)-1]
+		return strings.Join(reasons[:len(reasons)-1], ", ") + ", and " + reasons[len(reasons)-1]
+	}
+}
+
+// externalInputs returns the current values of the function's external inputs. The value of an
+// environment variable is keyed by "env:" followed by its name, and is empty if the variable is
+// not set. The value of a tool is keyed by "tool:" followed by its name, and holds the path of
+// the tool's executable and the SHA-256 sum of its contents. The value of a tool that cannot be
+// found is empty.
+func (f *function) externalInputs(ctx context.Context) (map[string]string, error) {
+	inputs := make(map[string]string, len(f.env)+len(f.tools))
+	for _, name := range f.env {
+		if value, ok := os.LookupEnv(name); ok {
+			inputs["env:"+name] = "=" + value
+		} else {
+			inputs["env:"+name] = ""
+		}
+	}
+	for _, name := range f.tools {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			inputs["tool:"+name] = ""
+			continue
+		}
+		sum, err := f.proj.fileSum(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("hashing tool %v: %w", name, err)
+		}
+		inputs["tool:"+name] = path + "\x00" + sum
+	}
+	return inputs, nil
+}
+
+// diffInputs returns a description of the external inputs that have changed since the function's
+// last execution, or the empty string if no inputs have changed.
+func (f *function) diffInputs() string {
+	var reasons []string
+	changed := func(key string) bool {
+		old, ok := f.inputs[key]
+		return !ok || old != f.newInputs[key]
+	}
+	for _, name := range f.env {
+		if changed("env:" + name) {
+			reasons = append(reasons, "environment variable "+name)
+		}
+	}
+	for _, name := range f.tools {
+		if changed("tool:" + name) {
+			reasons = append(reasons, "tool "+name)
+		}
 	}
-	return false, reason + " changed", d, nil
+	if len(reasons) == 0 {
+		return ""
+	}
+	return joinReasons(reasons) + " changed"
 }
 
-func (f *function) upToDate(_ context.Context) (bool, string, diff.ValueDiff, error) {
+func (f *function) upToDate(ctx context.Context) (bool, string, diff.ValueDiff, error) {
 	// check env
-	newEnv, err := functionEnv(f.function)
+	newEnv, err := f.proj.functionEnv(f.function)
 	if err != nil {
 		return false, "", nil, fmt.Errorf("computing function environment: %w", err)
 	}
 	f.newEnv = newEnv
 
+	// check external inputs
+	inputs, err := f.externalInputs(ctx)
+	if err != nil {
+		return false, "", nil, fmt.Errorf("checking external inputs: %w", err)
+	}
+	f.newInputs = inputs
+
+	// a service that is not running is out-of-date
+	if f.service && !f.proj.services.running(f.label.String()) {
+		return false, "service is not running", nil, nil
+	}
+
 	// if this target always runs, skip the equality check
 	if f.always {
 		f.targetInfo.Rerun = true
@@ -199,22 +309,53 @@ func (f *function) upToDate(_ context.Context) (bool, string, diff.ValueDiff, er
 	if err != nil || !eq {
 		return false, reason, diff, err
 	}
+	if reason := f.diffInputs(); reason != "" {
+		return false, reason, nil, nil
+	}
+
+	// check the sources listed in the function's depfile after its last run
+	for _, path := range slices.Sorted(maps.Keys(f.discovered)) {
+		sum, err := f.proj.fileSum(ctx, f.discoveredPath(path))
+		if err != nil {
+			if os.IsNotExist(err) {
+				reason := fmt.Sprintf("discovered source %v does not exist", path)
+				return false, reason, nil, nil
+			}
+			return false, "", nil, fmt.Errorf("checking discovered sources: %w", err)
+		}
+		if sum != f.discovered[path] {
+			reason := fmt.Sprintf("discovered source %v changed", path)
+			return false, reason, nil, nil
+		}
+	}
 
-	// if this target generates files, check to see that they exist
+	// if this target generates files, check to see that they exist and that their contents
+	// match those recorded after the target's last run
 	for _, out := range f.gens {
-		if _, err = os.Stat(out); err != nil {
+		rel := f.proj.relPath(out)
+
+		sum, err := f.proj.fileSum(ctx, out)
+		if err != nil {
 			if os.IsNotExist(err) {
-				// TODO: make this path relative to the project root
-				reason := fmt.Sprintf("generated file %v does not exist", out)
+				reason := fmt.Sprintf("generated file %v does not exist", rel)
 				return false, reason, nil, nil
 			}
 			return false, "", nil, fmt.Errorf("checking generated files: %w", err)
 		}
+
+		// outputs is nil if the target's last run predates output tracking
+		if f.outputs == nil {
+			continue
+		}
+		if oldSum, ok := f.outputs[rel]; !ok || oldSum != sum {
+			reason := fmt.Sprintf("generated file %v changed", rel)
+			return false, reason, nil, nil
+		}
 	}
 	return true, "", nil, nil
 }
 
-func (f *function) newThread(ctx context.Context) (*starlark.Thread, func()) {
+func (f *function) newThread(ctx context.Context, reqs *requirements) (*starlark.Thread, func()) {
 	thread := &starlark.Thread{
 		Name: f.label.String(),
 		Print: func(_ *starlark.Thread, msg string) {
@@ -225,19 +366,142 @@ func (f *function) newThread(ctx context.Context) (*starlark.Thread, func()) {
 		},
 	}
 
-	components := label.Split(f.label.Package)[1:]
-	wd := filepath.Join(f.proj.root, filepath.Join(components...))
-	util.Chdir(thread, wd)
+	util.Chdir(thread, f.dir())
 
 	util.SetStdio(thread, f.out, f.out)
 
 	thread.SetLocal("root", f.proj.root)
 	thread.SetLocal("module", f.module)
+	if reqs != nil {
+		thread.SetLocal("requirements", reqs)
+	}
+
+	if audit := f.proj.audit(f.label.String()); audit != nil {
+		s := f.newSandbox()
+		s.Audit, s.Skip = audit, f.proj.skip
+		sandbox.SetThreadSandbox(thread, s)
+	} else if f.sandbox || f.proj.sandbox {
+		sandbox.SetThreadSandbox(thread, f.newSandbox())
+	}
 
 	return thread, util.SetContext(ctx, thread)
 }
 
-func (f *function) evaluate(ctx context.Context) (data string, changed bool, err error) {
+// newSandbox returns the sandbox for the processes run by the function. Sandboxed processes may
+// read the function's sources and the files generated by its transitive dependencies, and may
+// write the files the function generates and its depfile.
+func (f *function) newSandbox() *sandbox.Sandbox {
+	inputs := append(slices.Clone(f.sources), f.proj.dependencyInputs(f.deps)...)
+
+	outputs := f.gens
+	if f.depfile != "" && !slices.Contains(outputs, f.depfile) {
+		outputs = append(slices.Clip(outputs), f.depfile)
+	}
+
+	return &sandbox.Sandbox{
+		Target:  f.label.String(),
+		Root:    f.proj.root,
+		Temp:    f.proj.temp,
+		Inputs:  inputs,
+		Outputs: outputs,
+	}
+}
+
+// dependencyInputs returns the paths of the files that the given dependencies make visible to a
+// sandboxed function: the source files that are direct dependencies and the files generated by
+// the dependencies and their transitive dependencies.
+func (proj *Project) dependencyInputs(deps []string) []string {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	var inputs []string
+	visited := map[string]bool{}
+	var visit func(deps []string, direct bool)
+	visit = func(deps []string, direct bool) {
+		for _, dep := range deps {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+
+			t, ok := proj.targets[dep]
+			if !ok {
+				continue
+			}
+			switch t := t.target.(type) {
+			case *sourceFile:
+				if direct || t.generator != nil {
+					inputs = append(inputs, t.path)
+				}
+			case *function:
+				inputs = append(inputs, t.gens...)
+				visit(t.deps, false)
+			}
+		}
+	}
+	visit(deps, true)
+	return inputs
+}
+
+// evaluate evaluates the function. Failed attempts are retried up to the function's retry limit.
+// If the function is a service, it is started in the background and its results are recorded
+// immediately.
+func (f *function) evaluate(ctx context.Context, engine runner.Engine) (data string, changed bool, err error) {
+	if f.service {
+		f.proj.services.start(ctx, f)
+		return f.record(ctx)
+	}
+
+	for attempt := 1; ; attempt++ {
+		data, changed, err = f.attempt(ctx, engine)
+		switch {
+		case err == nil || ctx.Err() != nil:
+			return data, changed, err
+		case attempt > f.retries:
+			if f.retries != 0 {
+				err = fmt.Errorf("%w (after %v attempts)", err, attempt)
+			}
+			return "", false, err
+		}
+		f.proj.events.TargetRetrying(f.label, attempt, err)
+	}
+}
+
+// attempt makes a single attempt to evaluate the function. If the function has a timeout, the
+// attempt is cancelled once the timeout elapses.
+func (f *function) attempt(ctx context.Context, engine runner.Engine) (string, bool, error) {
+	if f.timeout == 0 {
+		return f.call(ctx, engine)
+	}
+
+	timeout := &TimeoutError{Timeout: f.timeout}
+	ctx, cancel := context.WithTimeoutCause(ctx, f.timeout, timeout)
+	defer cancel()
+
+	data, changed, err := f.call(ctx, engine)
+	if err != nil && context.Cause(ctx) == timeout {
+		return "", false, timeout
+	}
+	return data, changed, err
+}
+
+// call calls the function's callback and records its results. The given engine builds any
+// targets the callback requires.
+func (f *function) call(ctx context.Context, engine runner.Engine) (data string, changed bool, err error) {
+	reqs := &requirements{f: f, engine: engine, stamps: map[string]string{}}
+	if err := f.callback(ctx, reqs); err != nil {
+		return "", false, err
+	}
+	if data, changed, err = f.record(ctx); err != nil {
+		return "", false, err
+	}
+	f.required = reqs.stamps
+	return data, changed, nil
+}
+
+// callback calls the function's callback. If reqs is nil, the callback may not require other
+// targets.
+func (f *function) callback(ctx context.Context, reqs *requirements) error {
 	defer f.out.Flush()
 
 	var args starlark.Tuple
@@ -245,22 +509,280 @@ func (f *function) evaluate(ctx context.Context) (data string, changed bool, err
 		args = starlark.Tuple{f}
 	}
 
-	thread, done := f.newThread(ctx)
+	thread, done := f.newThread(ctx, reqs)
 	defer done()
-	_, err = starlark.Call(thread, f.function, args, nil)
+	_, err := starlark.Call(thread, f.function, args, nil)
+	return err
+}
+
+// requirements records the targets required by a call to a function's callback.
+type requirements struct {
+	f      *function
+	engine runner.Engine
+
+	m      sync.Mutex
+	stamps map[string]string // the stamps of the required targets, keyed by label
+}
+
+// require builds the targets with the given labels, records their stamps, and returns them.
+func (r *requirements) require(ctx context.Context, labels []string) ([]Target, error) {
+	r.f.proj.events.TargetWaiting(r.f.label, labels)
+
+	targets := make([]Target, len(labels))
+	for i, result := range r.engine.EvaluateTargets(ctx, labels...) {
+		switch err := result.Error.(type) {
+		case nil:
+			// OK
+		case UnknownTargetError, *runner.CyclicDependencyError:
+			return nil, err
+		default:
+			return nil, fmt.Errorf("required target %v failed", labels[i])
+		}
+
+		t := result.Target.(*runTarget)
+		targets[i] = t.target
+
+		r.m.Lock()
+		r.stamps[labels[i]] = t.stamp()
+		r.m.Unlock()
+	}
+	return targets, nil
+}
+
+// record records the results of a successful call to the function's callback.
+func (f *function) record(ctx context.Context) (data string, changed bool, err error) {
+	outputs, err := f.outputSums(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("hashing generated files: %w", err)
+	}
+	discovered, err := f.discover(ctx)
 	if err != nil {
 		return "", false, err
 	}
 
+	outputsDict := starlark.NewDict(len(outputs))
+	for _, path := range slices.Sorted(maps.Keys(outputs)) {
+		util.Must(outputsDict.SetKey(starlark.String(path), starlark.String(outputs[path])))
+	}
+
+	// External inputs are only recorded by functions that declare them.
+	value := starlark.Tuple{f.function, outputsDict}
+	if len(f.newInputs) != 0 {
+		inputsDict := starlark.NewDict(len(f.newInputs))
+		for _, key := range slices.Sorted(maps.Keys(f.newInputs)) {
+			util.Must(inputsDict.SetKey(starlark.String(key), starlark.String(f.newInputs[key])))
+		}
+		value = append(value, inputsDict)
+	}
+
 	var buf bytes.Buffer
 	b64 := base64.NewEncoder(base64.StdEncoding, &buf)
-	if err := pickle.NewEncoder(b64, pickle.PicklerFunc(envPickler)).Encode(f.function); err != nil {
+	if err := pickle.NewEncoder(b64, pickle.PicklerFunc(f.proj.envPickler)).Encode(value); err != nil {
 		return "", false, err
 	}
 	util.Must(b64.Close())
 
-	f.oldEnv = f.newEnv
-	return buf.String(), true, nil
+	// A function that does not generate any files is always considered changed. Otherwise, the
+	// function has changed if this is its first run or if any of its outputs changed.
+	changed = f.oldEnv == starlark.None || len(f.gens) == 0 || !maps.Equal(f.outputs, outputs)
+
+	f.oldEnv, f.outputs, f.inputs, f.discovered = f.newEnv, outputs, f.newInputs, discovered
+	return buf.String(), changed, nil
+}
+
+// dir returns the path of the function's package directory.
+func (f *function) dir() string {
+	components := label.Split(f.label.Package)[1:]
+	return filepath.Join(f.proj.root, filepath.Join(components...))
+}
+
+// discover parses the function's depfile, if any, and returns the SHA-256 sums of the sources it
+// lists, keyed by their project-relative paths. Relative paths in the depfile are interpreted
+// relative to the function's package directory. Sources that do not exist are omitted.
+func (f *function) discover(ctx context.Context) (map[string]string, error) {
+	if f.depfile == "" {
+		return nil, nil
+	}
+
+	paths, err := func() ([]string, error) {
+		file, err := os.Open(f.depfile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return parseDepfile(file)
+	}()
+	if err != nil {
+		return nil, fmt.Errorf("reading depfile %v: %w", f.proj.relPath(f.depfile), err)
+	}
+
+	sums := make(map[string]string, len(paths))
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(f.dir(), path)
+		}
+		sum, err := f.proj.fileSum(ctx, path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("hashing discovered sources: %w", err)
+		}
+		sums[f.proj.relPath(path)] = sum
+	}
+	return sums, nil
+}
+
+// discoveredPaths returns the absolute paths of the sources listed in the function's depfile after
+// its last run.
+func (f *function) discoveredPaths() []string {
+	paths := make([]string, 0, len(f.discovered))
+	for path := range f.discovered {
+		paths = append(paths, f.discoveredPath(path))
+	}
+	return paths
+}
+
+// discoveredPath returns the absolute path of the discovered source with the given
+// project-relative path.
+func (f *function) discoveredPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(f.proj.root, filepath.FromSlash(path))
+}
+
+// outputSums returns the SHA-256 sums of the function's generated files, keyed by their
+// project-relative paths. Generated files that do not exist are omitted.
+func (f *function) outputSums(ctx context.Context) (map[string]string, error) {
+	sums := make(map[string]string, len(f.gens))
+	for _, out := range f.gens {
+		sum, err := f.proj.fileSum(ctx, out)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		sums[f.proj.relPath(out)] = sum
+	}
+	return sums, nil
+}
+
+// stamp returns the value recorded by the function's dependents. For functions that generate
+// files, this is a digest of the generated files' contents, which allows dependents to remain
+// up-to-date when the function re-runs but produces identical outputs.
+func (f *function) stamp() string {
+	h := sha256.New()
+	for _, path := range slices.Sorted(maps.Keys(f.outputs)) {
+		fmt.Fprintf(h, "%s\x00%s\x00", path, f.outputs[path])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheable returns true if the function's results may be stored in the project's action
+// cache. Only functions that generate files and are not always run are cacheable. Functions are
+// not cacheable while the project is checking hermeticity, as audited runs do not produce the
+// functions' real outputs.
+func (f *function) cacheable() bool {
+	return f.proj.actions != nil && !f.always && len(f.gens) != 0 && !f.proj.auditing()
+}
+
+// actionKey returns the function's key in the action cache given the data of its dependencies.
+func (f *function) actionKey(depData map[string]string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", f.label)
+	for _, out := range f.gens {
+		fmt.Fprintf(h, "%s\x00", f.proj.relPath(out))
+	}
+	if err := pickle.NewEncoder(h, pickle.PicklerFunc(f.proj.envPickler)).Encode(f.function); err != nil {
+		return "", fmt.Errorf("computing function environment: %w", err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(f.newInputs)) {
+		fmt.Fprintf(h, "%s\x00%s\x00", key, f.newInputs[key])
+	}
+	for _, label := range slices.Sorted(maps.Keys(depData)) {
+		fmt.Fprintf(h, "%s\x00%s\x00", label, depData[label])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// restore attempts to restore the function's results from the given cached result. If
+// successful, the function's generated files are replaced with the cached files and restore
+// returns the function's data. A result is not restored if any of the sources listed in the
+// function's depfile when the result was cached have since changed, or if any of the targets the
+// function required have changed. The given engine builds the required targets.
+func (f *function) restore(ctx context.Context, engine runner.Engine, result *actionResult) (data string, changed, ok bool) {
+	for path, sum := range result.Discovered {
+		if current, err := f.proj.fileSum(ctx, f.discoveredPath(path)); err != nil || current != sum {
+			return "", false, false
+		}
+	}
+	if len(result.Required) != 0 {
+		labels := slices.Sorted(maps.Keys(result.Required))
+		for i, dep := range engine.EvaluateTargets(ctx, labels...) {
+			if dep.Error != nil || dep.Target.(*runTarget).stamp() != result.Required[labels[i]] {
+				return "", false, false
+			}
+		}
+	}
+
+	outputs := make(map[string]string, len(result.Outputs))
+	for _, out := range f.gens {
+		rel := f.proj.relPath(out)
+
+		output, ok := result.Outputs[rel]
+		if !ok {
+			// The function did not produce this file.
+			if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+				return "", false, false
+			}
+			continue
+		}
+		outputs[rel] = output.Sum
+
+		// Skip files that are already up-to-date.
+		if stat, err := os.Lstat(out); err == nil && stat.Mode().IsRegular() && stat.Mode().Perm() == output.Mode.Perm() {
+			if sum, err := f.proj.fileSum(ctx, out); err == nil && sum == output.Sum {
+				continue
+			}
+		}
+		if err := f.proj.actions.restore(output, out, f.proj.temp); err != nil {
+			return "", false, false
+		}
+	}
+
+	changed = f.oldEnv == starlark.None || !maps.Equal(f.outputs, outputs)
+
+	f.oldEnv, f.outputs, f.inputs, f.discovered = f.newEnv, outputs, f.newInputs, result.Discovered
+	f.required = result.Required
+	return result.Data, changed, true
+}
+
+// cache records the function's results in the action cache under the given key. Functions that
+// generate anything other than regular files are not cached.
+func (f *function) cache(ctx context.Context, key, data string) error {
+	result := actionResult{Data: data, Outputs: make(map[string]actionOutput, len(f.outputs)), Discovered: f.discovered, Required: f.required}
+	files := make(map[string]string, len(f.outputs))
+	for _, out := range f.gens {
+		rel := f.proj.relPath(out)
+
+		sum, ok := f.outputs[rel]
+		if !ok {
+			continue
+		}
+		stat, err := os.Lstat(out)
+		if err != nil {
+			return err
+		}
+		if !stat.Mode().IsRegular() {
+			return nil
+		}
+
+		result.Outputs[rel] = actionOutput{Sum: sum, Mode: stat.Mode().Perm()}
+		files[rel] = out
+	}
+	return f.proj.actions.store(ctx, key, &result, files)
 }
 
 func (f *function) load() error {
@@ -269,7 +791,13 @@ func (f *function) load() error {
 	if err != nil {
 		return fmt.Errorf("loading prior function environment: %w", err)
 	}
-	f.targetInfo = info
+	f.targetInfo, f.discovered = info, info.Discovered
+	if len(info.Required) != 0 {
+		f.required = make(map[string]string, len(info.Required))
+		for _, label := range info.Required {
+			f.required[label] = info.Dependencies[label]
+		}
+	}
 	if f.always {
 		f.targetInfo.Rerun = true
 	}
@@ -283,11 +811,42 @@ func (f *function) load() error {
 
 	if len(info.Data) == 0 {
 		f.oldEnv = starlark.None
-	} else {
-		b64 := base64.NewDecoder(base64.StdEncoding, strings.NewReader(info.Data))
-		f.oldEnv, err = pickle.NewDecoder(b64, pickle.UnpicklerFunc(envUnpickler)).Decode()
-		if err != nil {
-			return fmt.Errorf("loading prior function environment: %w", err)
+		return nil
+	}
+
+	b64 := base64.NewDecoder(base64.StdEncoding, strings.NewReader(info.Data))
+	data, err := pickle.NewDecoder(b64, pickle.UnpicklerFunc(envUnpickler)).Decode()
+	if err != nil {
+		return fmt.Errorf("loading prior function environment: %w", err)
+	}
+
+	// Data is either a pickled (environment, outputs) tuple, a pickled (environment, outputs,
+	// external inputs) tuple, or--if it was written by an older version of dawn--a bare pickled
+	// environment.
+	tuple, ok := data.(starlark.Tuple)
+	if !ok || len(tuple) != 2 && len(tuple) != 3 {
+		f.oldEnv = data
+		return nil
+	}
+	f.oldEnv = tuple[0]
+
+	if len(tuple) == 3 {
+		if inputs, ok := tuple[2].(*starlark.Dict); ok {
+			f.inputs = make(map[string]string, inputs.Len())
+			for _, kvp := range inputs.Items() {
+				key, _ := starlark.AsString(kvp[0])
+				value, _ := starlark.AsString(kvp[1])
+				f.inputs[key] = value
+			}
+		}
+	}
+
+	if outputs, ok := tuple[1].(*starlark.Dict); ok {
+		f.outputs = make(map[string]string, outputs.Len())
+		for _, kvp := range outputs.Items() {
+			path, _ := starlark.AsString(kvp[0])
+			sum, _ := starlark.AsString(kvp[1])
+			f.outputs[path] = sum
 		}
 	}
 
@@ -296,9 +855,9 @@ func (f *function) load() error {
 
 // functionEnv returns the given function's environment by round-tripping it through the
 // pickler.
-func functionEnv(f starlark.Callable) (starlark.Value, error) {
+func (proj *Project) functionEnv(f starlark.Callable) (starlark.Value, error) {
 	var buf bytes.Buffer
-	if err := pickle.NewEncoder(&buf, pickle.PicklerFunc(envPickler)).Encode(f); err != nil {
+	if err := pickle.NewEncoder(&buf, pickle.PicklerFunc(proj.envPickler)).Encode(f); err != nil {
 		return nil, err
 	}
 	return pickle.NewDecoder(&buf, pickle.UnpicklerFunc(envUnpickler)).Decode()
@@ -306,16 +865,30 @@ func functionEnv(f starlark.Callable) (starlark.Value, error) {
 
 // envPickler provides support for pickling functions and modules.
 //
-// - Builtins are pickled as (NEWOBJ "dawn" "Builtin" ())
-// - Function code is pickled as (NEWOBJ "dawn" "FunctionCode" (module, globals, bytecode))
-// - Functions are pickled as (NEWOBJ "dawn" "Function" (defaults, freevars, code)).
-func envPickler(x starlark.Value) (module, name string, args starlark.Tuple, err error) {
+//   - Builtins are pickled as (NEWOBJ "dawn" "Builtin" ())
+//   - Function code defined by one of the project's modules is pickled as
+//     (NEWOBJ "dawn" "FunctionSource" (sum, globals)), where sum is the sum of the function's source
+//     and globals holds the values of the module globals it references
+//   - Other function code is pickled as (NEWOBJ "dawn" "FunctionCode" (module, globals, bytecode))
+//   - Functions are pickled as (NEWOBJ "dawn" "Function" (defaults, freevars, code)).
+func (proj *Project) envPickler(x starlark.Value) (module, name string, args starlark.Tuple, err error) {
 	switch x := x.(type) {
 	case *function:
 		return "dawn", "Target", starlark.Tuple{starlark.String(x.label.String())}, nil
 	case *starlark.Builtin:
 		return "dawn", "Builtin", starlark.Tuple{}, nil
 	case *starlark.FunctionCode:
+		if source, ok := proj.functionSource(x); ok {
+			values := x.Globals()
+			globals := make(starlark.Tuple, 0, len(source.Globals))
+			for _, global := range source.Globals {
+				if v, ok := values[global]; ok {
+					globals = append(globals, starlark.Tuple{starlark.String(global), v})
+				}
+			}
+			return "dawn", "FunctionSource", starlark.Tuple{starlark.String(source.Sum), globals}, nil
+		}
+
 		module, globals := x.ModuleEnv()
 		return "dawn", "FunctionCode", starlark.Tuple{module, globals, starlark.Bytes(x.Bytecode())}, nil
 	case *starlark.Function:
@@ -329,6 +902,8 @@ func envPickler(x starlark.Value) (module, name string, args starlark.Tuple, err
 // envUnpickler provides support for unpickling functions and modules.
 //
 //   - Builtins are unpickled from (NEWOBJ "dawn" "Builtin" ()) into ()
+//   - Function sources are unpickled from (NEWOBJ "dawn" "FunctionSource" (sum, globals)) into a
+//     dictionary.
 //   - Function code is unpickled from (NEWOBJ "dawn" "FunctionCode" (module, globals, bytecode))
 //     into a dictionary.
 //   - Functions are unpickled from (NEWOBJ "dawn" "Function" (defaults, freevars, code))
@@ -349,6 +924,14 @@ func envUnpickler(module, name string, args starlark.Tuple) (starlark.Value, err
 			return nil, fmt.Errorf("expected 0 args, got %v", len(args))
 		}
 		return args, nil
+	case "FunctionSource":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected 2 args, got %v", len(args))
+		}
+		dict := starlark.NewDict(2)
+		util.Must(dict.SetKey(starlark.String("source"), args[0]))
+		util.Must(dict.SetKey(starlark.String("global values"), makeDictFromAssociationList(args[1])))
+		return dict, nil
 	case "FunctionCode":
 		if len(args) != 3 {
 			return nil, fmt.Errorf("expcted 3 args, got %v", len(args))
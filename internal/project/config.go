@@ -17,12 +17,35 @@ type RequirementConfig struct {
 	Version string `toml:"version,inline"`
 }
 
+// Remote cache modes.
+const (
+	// CacheReadOnly indicates that results are fetched from the remote cache but never
+	// written to it.
+	CacheReadOnly = "read-only"
+	// CacheWriteThrough indicates that results are fetched from the remote cache and that
+	// new results are written to both the local and remote caches.
+	CacheWriteThrough = "write-through"
+)
+
+type CacheConfig struct {
+	Remote string `toml:"remote,omitempty"`
+	Mode   string `toml:"mode,omitempty"`
+}
+
 type Config struct {
 	Name    string `toml:"name,omitempty"`
 	Version string `toml:"version,omitempty"`
 
 	Ignore []string `toml:"ignore,omitempty"`
 
+	// Sandbox runs the processes started by every target in a sandbox.
+	Sandbox bool `toml:"sandbox,omitempty"`
+
+	Cache CacheConfig `toml:"cache,omitempty"`
+
+	// Resources holds the capacity of each of the project's resource pools.
+	Resources map[string]int `toml:"resources,omitempty"`
+
 	Requirements map[string]RequirementConfig `toml:"requirements,omitempty"`
 }
 
@@ -42,6 +65,14 @@ func LoadConfigBytes(contents []byte) (*Config, error) {
 	}
 
 	var errs []error
+	if err := ValidateCacheMode(c.Cache.Mode); err != nil {
+		errs = append(errs, err)
+	}
+	for _, k := range slices.Sorted(maps.Keys(c.Resources)) {
+		if c.Resources[k] <= 0 {
+			errs = append(errs, fmt.Errorf("invalid capacity %v for resource pool %q (must be positive)", c.Resources[k], k))
+		}
+	}
 	for _, k := range slices.Sorted(maps.Keys(c.Requirements)) {
 		req := c.Requirements[k]
 		if !semver.IsValid(req.Version) || semver.Canonical(req.Version) != req.Version {
@@ -57,6 +88,17 @@ func LoadConfigBytes(contents []byte) (*Config, error) {
 	return &c, nil
 }
 
+// ValidateCacheMode returns an error if the given remote cache mode is not valid. The empty
+// string is a valid mode, and is equivalent to CacheReadOnly.
+func ValidateCacheMode(mode string) error {
+	switch mode {
+	case "", CacheReadOnly, CacheWriteThrough:
+		return nil
+	default:
+		return fmt.Errorf("invalid cache mode %q (must be %q or %q)", mode, CacheReadOnly, CacheWriteThrough)
+	}
+}
+
 func WriteConfigFile(path string, c *Config) error {
 	//nolint:gosec
 	f, err := os.Create(path)
@@ -88,16 +130,32 @@ func WriteConfigFile(path string, c *Config) error {
 		printSection("ignore = %v\n", encodeValue(c.Ignore))
 	}
 
+	if c.Sandbox {
+		printSection("sandbox = true\n")
+	}
+
+	if c.Cache != (CacheConfig{}) {
+		printSection("[cache]\n")
+		if c.Cache.Remote != "" {
+			print("remote = %v\n", encodeValue(c.Cache.Remote))
+		}
+		if c.Cache.Mode != "" {
+			print("mode = %v\n", encodeValue(c.Cache.Mode))
+		}
+	}
+
+	if len(c.Resources) != 0 {
+		printSection("[resources]\n")
+		for _, name := range slices.Sorted(maps.Keys(c.Resources)) {
+			print("%v = %v\n", encodeKey(name), c.Resources[name])
+		}
+	}
+
 	if len(c.Requirements) != 0 {
 		printSection("[requirements]\n")
 		for _, name := range slices.Sorted(maps.Keys(c.Requirements)) {
 			req := c.Requirements[name]
-
-			mustQuote := strings.ContainsFunc(name, func(r rune) bool { return !isPlainRune(r) })
-			if mustQuote {
-				name = encodeValue(name)
-			}
-			print("%v = {path = %v, version = %v}\n", name, encodeValue(req.Path), encodeValue(req.Version))
+			print("%v = {path = %v, version = %v}\n", encodeKey(name), encodeValue(req.Path), encodeValue(req.Version))
 		}
 	}
 
@@ -113,6 +171,13 @@ func encodeValue(v any) string {
 	return b.String()
 }
 
+func encodeKey(k string) string {
+	if strings.ContainsFunc(k, func(r rune) bool { return !isPlainRune(r) }) {
+		return encodeValue(k)
+	}
+	return k
+}
+
 func isPlainRune(r rune) bool {
 	return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-'
 }
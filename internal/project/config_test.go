@@ -28,3 +28,15 @@ func TestRoundTripConfigFile(t *testing.T) {
 
 	assert.Equal(t, string(expected), string(actual))
 }
+
+func TestInvalidCacheMode(t *testing.T) {
+	t.Parallel()
+	_, err := LoadConfigBytes([]byte("[cache]\nmode = 'read-write'\n"))
+	assert.ErrorContains(t, err, `invalid cache mode "read-write"`)
+}
+
+func TestInvalidResourceCapacity(t *testing.T) {
+	t.Parallel()
+	_, err := LoadConfigBytes([]byte("[resources]\ndb = 0\n"))
+	assert.ErrorContains(t, err, `invalid capacity 0 for resource pool "db"`)
+}
@@ -0,0 +1,276 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Audit records the undeclared files that are accessed by the commands run in a sandbox.
+//
+// Rather than hiding undeclared files, an auditing sandbox's staging directory contains a copy of
+// each input and an empty placeholder for every other file in the project. Each placeholder's
+// access time is reset before a command runs, and any placeholder that has been accessed once
+// the command exits was read by the command. Any other file that was created or modified by the
+// command and is not one of its outputs was written by the command. Reads are only detected on
+// filesystems that record access times.
+//
+// Audited commands never modify the project. Their outputs are moved into a scratch directory
+// instead, and the files in the scratch directory take precedence over the project's files when
+// later audited commands are staged.
+type Audit struct {
+	scratch string
+
+	m      sync.Mutex
+	reads  map[string]bool
+	writes map[string]bool
+}
+
+// NewAudit creates a new, empty audit that collects outputs into the given scratch directory.
+// Audits that share a scratch directory observe each other's outputs.
+func NewAudit(scratch string) *Audit {
+	return &Audit{scratch: scratch, reads: map[string]bool{}, writes: map[string]bool{}}
+}
+
+// Reads returns the sorted root-relative paths of the undeclared files that were read.
+func (a *Audit) Reads() []string {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return slices.Sorted(maps.Keys(a.reads))
+}
+
+// Writes returns the sorted root-relative paths of the undeclared files that were written.
+func (a *Audit) Writes() []string {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return slices.Sorted(maps.Keys(a.writes))
+}
+
+func (a *Audit) record(reads, writes []string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	for _, path := range reads {
+		a.reads[filepath.ToSlash(path)] = true
+	}
+	for _, path := range writes {
+		a.writes[filepath.ToSlash(path)] = true
+	}
+}
+
+// A stagedFile records the state of a file in an auditing sandbox's staging directory.
+type stagedFile struct {
+	placeholder bool
+	size        int64
+	modTime     time.Time
+}
+
+// stageAudit populates the given staging directory for an audit. Each input is copied into the
+// staging directory, and every other file in the project is represented by an empty placeholder
+// whose access time is reset. Files in the audit's scratch directory replace the project's files.
+// Outputs, the project's .dawn and .git directories, and the files skipped by the sandbox are not
+// staged. The returned map records the state of each staged file, keyed by its root-relative path.
+func (s *Sandbox) stageAudit(staging string) (map[string]stagedFile, error) {
+	files := map[string]stagedFile{}
+	if err := s.stageTree(staging, s.Root, files); err != nil {
+		return nil, err
+	}
+	if err := s.stageTree(staging, s.Audit.scratch, files); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// stageTree stages the files beneath the given directory for an audit, replacing any files that
+// have already been staged. The state of each staged file is recorded in the given map.
+func (s *Sandbox) stageTree(staging, dir string, files map[string]stagedFile) error {
+	inputs, outputs := s.relPaths(s.Inputs), s.relPaths(s.Outputs)
+
+	replace := dir != s.Root
+
+	epoch := time.Unix(0, 0)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(staging, rel)
+
+		switch {
+		case within(outputs, rel):
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		case d.IsDir():
+			if d.Name() == ".dawn" || d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			if s.skip(rel, true) && !contains(inputs, rel) && !contains(outputs, rel) {
+				return fs.SkipDir
+			}
+			return os.MkdirAll(target, 0o750)
+		case s.skip(rel, false) && !within(inputs, rel):
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			dest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			return os.Symlink(dest, target)
+		case !d.Type().IsRegular():
+			return nil
+		}
+
+		// Don't write through a symlink that was staged in place of a replaced file.
+		if replace {
+			if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+
+		placeholder := !within(inputs, rel)
+		if placeholder {
+			err = os.WriteFile(target, nil, 0o600)
+		} else {
+			err = copyFile(path, target)
+		}
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		if placeholder {
+			if err := os.Chtimes(target, epoch, info.ModTime()); err != nil {
+				return err
+			}
+		}
+		files[rel] = stagedFile{placeholder: placeholder, size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+}
+
+// collectAudit moves the outputs written by a command from the given staging directory into the
+// audit's scratch directory and records the undeclared files that the command read or wrote.
+func (s *Sandbox) collectAudit(staging string, files map[string]stagedFile) error {
+	outputs := s.relPaths(s.Outputs)
+
+	var reads, writes []string
+	err := filepath.WalkDir(staging, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+
+		if outputs[rel] {
+			return moveOutput(path, filepath.Join(s.Audit.scratch, rel), d)
+		}
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		staged, ok := files[rel]
+		if staged.placeholder && accessed(info) {
+			reads = append(reads, rel)
+		}
+		if !ok || info.Size() != staged.size || !info.ModTime().Equal(staged.modTime) {
+			writes = append(writes, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("sandbox: collecting outputs of %v: %w", s.Target, err)
+	}
+
+	s.Audit.record(reads, writes)
+	return nil
+}
+
+// relPaths returns the set of root-relative paths of the given paths that are within the
+// project root.
+func (s *Sandbox) relPaths(paths []string) map[string]bool {
+	set := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if rel, ok := s.rel(path); ok {
+			set[rel] = true
+		}
+	}
+	return set
+}
+
+// within returns true if the given root-relative path or any of its parent directories is in the
+// given set.
+func within(set map[string]bool, rel string) bool {
+	for ; rel != "." && rel != string(filepath.Separator); rel = filepath.Dir(rel) {
+		if set[rel] {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if the given set holds a path beneath the given root-relative directory.
+func contains(set map[string]bool, dir string) bool {
+	prefix := dir + string(filepath.Separator)
+	for rel := range set {
+		if strings.HasPrefix(rel, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip returns true if the file or directory at the given root-relative path should not be staged
+// for an audit.
+func (s *Sandbox) skip(rel string, isDir bool) bool {
+	return s.Skip != nil && s.Skip(filepath.ToSlash(rel), isDir)
+}
+
+// copyFile copies the contents and permissions of the source file to the target path.
+func copyFile(source, target string) (err error) {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dest, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, dest.Close())
+	}()
+
+	_, err = io.Copy(dest, src)
+	return err
+}
@@ -0,0 +1,284 @@
+// Package sandbox runs commands in an environment that restricts the project files they can
+// access to those declared by the target that runs them.
+//
+// On Linux, sandboxed commands run inside new user, mount, and network namespaces. The project
+// root is replaced by a staging directory that contains only the target's inputs, which are
+// mounted read-only, and the directories of its outputs. Once the command exits, the outputs it
+// wrote are moved into the project, and any other files it wrote are reported as errors.
+//
+// Entering the namespaces requires the running executable to re-execute itself, so any program
+// that runs sandboxed commands must import this package.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// specEnv is the name of the environment variable that passes a spec to a sandboxed process.
+const specEnv = "DAWN_SANDBOX_SPEC"
+
+// cancelDelay bounds the time spent waiting for an interrupted shell to exit.
+const cancelDelay = 5 * time.Second
+
+// A Sandbox describes the files that are visible to the commands run by a target.
+type Sandbox struct {
+	// Target is the label of the target that runs the sandboxed commands.
+	Target string
+	// Root is the project's root directory. Files outside of the root are not restricted.
+	Root string
+	// Temp is the directory in which staging directories are created. It must be on the same
+	// filesystem as Root.
+	Temp string
+	// Inputs holds the paths of the files that are visible to sandboxed commands.
+	Inputs []string
+	// Outputs holds the paths of the files that sandboxed commands may write.
+	Outputs []string
+	// Audit, if non-nil, records the undeclared files accessed by sandboxed commands. Undeclared
+	// files are visible to the commands of an auditing sandbox, and accessing them is not an
+	// error. The outputs of an auditing sandbox are collected into the audit's scratch directory
+	// rather than the project.
+	Audit *Audit
+	// Skip, if non-nil, returns true if the file or directory with the given slash-separated,
+	// root-relative path should be left out of an auditing sandbox's staging directory. Inputs
+	// and the directories that contain inputs or outputs are always staged.
+	Skip func(rel string, isDir bool) bool
+}
+
+// A spec describes a single sandboxed process to the sandbox helper.
+type spec struct {
+	Root    string   `json:"root"`
+	Staging string   `json:"staging"`
+	Inputs  []string `json:"inputs"`
+	Path    string   `json:"path,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Shell   string   `json:"shell,omitempty"`
+}
+
+// Run runs the given command inside the sandbox. The command's Path and Args determine the
+// executable that is run; its remaining fields are used as-is.
+func (s *Sandbox) Run(cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	return s.run(cmd, spec{Path: cmd.Path, Args: cmd.Args})
+}
+
+// RunShell runs the given shell program inside the sandbox. The program is interpreted by the
+// same shell as the sh module, which runs in-process in the sandbox helper, so no external shell
+// is required.
+func (s *Sandbox) RunShell(ctx context.Context, program, dir string, env []string, stdout, stderr io.Writer) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, self)
+	cmd.Dir, cmd.Env, cmd.Stdout, cmd.Stderr = dir, env, stdout, stderr
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = cancelDelay
+	return s.run(cmd, spec{Shell: program})
+}
+
+// run stages the sandbox's files, runs the given command inside the sandbox, and then collects
+// the command's outputs.
+func (s *Sandbox) run(cmd *exec.Cmd, sp spec) (err error) {
+	attr, err := sysProcAttr()
+	if err != nil {
+		return fmt.Errorf("%v: %w", s.Target, err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+
+	if err := os.MkdirAll(s.Temp, 0o750); err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	staging, err := os.MkdirTemp(s.Temp, "sandbox-")
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, os.RemoveAll(staging))
+	}()
+
+	sp.Root, sp.Staging = s.Root, staging
+
+	var collect func() error
+	if s.Audit != nil {
+		files, err := s.stageAudit(staging)
+		if err != nil {
+			return fmt.Errorf("sandbox: staging files for %v: %w", s.Target, err)
+		}
+		collect = func() error { return s.collectAudit(staging, files) }
+	} else {
+		placeholders, err := s.stage(staging)
+		if err != nil {
+			return fmt.Errorf("sandbox: staging files for %v: %w", s.Target, err)
+		}
+		for _, path := range s.Inputs {
+			if rel, ok := s.rel(path); ok && placeholders[rel] {
+				sp.Inputs = append(sp.Inputs, rel)
+			}
+		}
+		collect = func() error { return s.collect(staging, placeholders) }
+	}
+	if err := s.stageDirs(staging, cmd.Dir); err != nil {
+		return fmt.Errorf("sandbox: staging files for %v: %w", s.Target, err)
+	}
+
+	encoded, err := json.Marshal(sp)
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Path, cmd.Args, cmd.Env = self, []string{self}, append(env[:len(env):len(env)], specEnv+"="+string(encoded))
+	cmd.SysProcAttr = attr
+
+	runErr := cmd.Run()
+	if err := collect(); err != nil {
+		return errors.Join(runErr, err)
+	}
+	switch {
+	case runErr != nil && s.Audit != nil:
+		return runErr
+	case runErr != nil:
+		return fmt.Errorf("%w (%v is sandboxed, and may only read its declared sources and the outputs of its dependencies and only write its declared outputs)", runErr, s.Target)
+	default:
+		return nil
+	}
+}
+
+// rel returns the root-relative path of the given path and true if the path is within the
+// project root.
+func (s *Sandbox) rel(path string) (string, bool) {
+	rel, err := filepath.Rel(s.Root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// stage creates the skeleton of the sandbox's project root in the given staging directory.
+// Each input that exists is represented by an empty file or directory over which the input is
+// mounted. The returned set holds the root-relative paths of the inputs' placeholders.
+func (s *Sandbox) stage(staging string) (map[string]bool, error) {
+	placeholders := map[string]bool{}
+	for _, path := range s.Inputs {
+		rel, ok := s.rel(path)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		target := filepath.Join(staging, rel)
+		if info.IsDir() {
+			err = os.MkdirAll(target, 0o750)
+		} else {
+			if err = os.MkdirAll(filepath.Dir(target), 0o750); err == nil {
+				err = os.WriteFile(target, nil, 0o600)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		placeholders[rel] = true
+	}
+	return placeholders, nil
+}
+
+// stageDirs creates the given working directory and the directories of the sandbox's outputs
+// in the given staging directory.
+func (s *Sandbox) stageDirs(staging, dir string) error {
+	dirs := []string{dir}
+	for _, path := range s.Outputs {
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	for _, dir := range dirs {
+		if rel, ok := s.rel(dir); ok {
+			if err := os.MkdirAll(filepath.Join(staging, rel), 0o750); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collect moves the outputs written by a sandboxed command from the given staging directory
+// into the project and returns an error that names any other files the command wrote.
+func (s *Sandbox) collect(staging string, placeholders map[string]bool) error {
+	outputs := s.relPaths(s.Outputs)
+
+	var undeclared []string
+	err := filepath.WalkDir(staging, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case placeholders[rel]:
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		case outputs[rel]:
+			return moveOutput(path, filepath.Join(s.Root, rel), d)
+		case d.IsDir():
+			return nil
+		default:
+			undeclared = append(undeclared, filepath.ToSlash(rel))
+			return nil
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("sandbox: collecting outputs of %v: %w", s.Target, err)
+	}
+	if len(undeclared) != 0 {
+		slices.Sort(undeclared)
+		return fmt.Errorf("%v wrote undeclared files: %v", s.Target, strings.Join(undeclared, ", "))
+	}
+	return nil
+}
+
+// moveOutput moves an output from the staging directory to its destination in the project,
+// replacing any existing file. It is called while walking the staging directory, so it returns
+// fs.SkipDir if the output is a directory.
+func moveOutput(path, dest string, d fs.DirEntry) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return err
+	}
+	if d.IsDir() {
+		return fs.SkipDir
+	}
+	return nil
+}
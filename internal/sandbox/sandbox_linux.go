@@ -0,0 +1,184 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// If the process was started as a sandbox helper, run the sandboxed command instead of the
+// program's main function.
+func init() {
+	encoded, ok := os.LookupEnv(specEnv)
+	if !ok {
+		return
+	}
+	os.Exit(helper(encoded))
+}
+
+// sysProcAttr returns the attributes of a sandbox helper process. The helper runs as root inside
+// new user, mount, and network namespaces so that it is able to set up the sandbox's mounts.
+func sysProcAttr() (*syscall.SysProcAttr, error) {
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}, nil
+}
+
+// helper sets up the sandbox described by the given encoded spec and runs its command. It
+// returns the exit code of the process.
+func helper(encoded string) int {
+	if err := os.Unsetenv(specEnv); err != nil {
+		return fail(err)
+	}
+
+	var sp spec
+	if err := json.Unmarshal([]byte(encoded), &sp); err != nil {
+		return fail(err)
+	}
+
+	// The working directory refers to the project's actual files until it is re-entered after
+	// the staging directory is mounted over the project root.
+	wd, err := os.Getwd()
+	if err != nil {
+		return fail(err)
+	}
+	if err := mount(&sp); err != nil {
+		return fail(err)
+	}
+	if err := os.Chdir(wd); err != nil {
+		return fail(err)
+	}
+	if err := loopbackUp(); err != nil {
+		return fail(err)
+	}
+
+	if sp.Shell != "" {
+		return shell(sp.Shell, wd)
+	}
+
+	path := sp.Path
+	if !strings.Contains(path, string(filepath.Separator)) {
+		path = filepath.Join(wd, path)
+	}
+	err = syscall.Exec(path, sp.Args, os.Environ())
+	if errors.Is(err, syscall.ENOENT) {
+		fmt.Fprintf(os.Stderr, "sandbox: %v: %v\n", sp.Path, err)
+		return 127
+	}
+	return fail(err)
+}
+
+// fail reports an error that prevented the sandboxed command from running.
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	return 125
+}
+
+// mount mounts the spec's inputs read-only over their placeholders in the staging directory and
+// then mounts the staging directory over the project root.
+func mount(sp *spec) error {
+	// Keep the sandbox's mounts from propagating to the parent namespace.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+
+	for _, rel := range sp.Inputs {
+		if err := bindReadOnly(filepath.Join(sp.Root, rel), filepath.Join(sp.Staging, rel)); err != nil {
+			return fmt.Errorf("mounting %v: %w", rel, err)
+		}
+	}
+	if err := unix.Mount(sp.Staging, sp.Root, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("mounting project root: %w", err)
+	}
+	return nil
+}
+
+// bindReadOnly mounts the source path over the target path and makes the mount read-only.
+func bindReadOnly(source, target string) error {
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+
+	// A bind mount inherits the flags of the filesystem that contains the source. The flags of
+	// that filesystem may be locked, in which case they must be preserved when remounting.
+	var st unix.Statfs_t
+	if err := unix.Statfs(target, &st); err != nil {
+		return err
+	}
+	locked := uintptr(st.Flags) & (unix.ST_NOSUID | unix.ST_NODEV | unix.ST_NOEXEC | unix.ST_NOATIME | unix.ST_NODIRATIME | unix.ST_RELATIME)
+	return unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|locked, "")
+}
+
+// loopbackUp brings up the loopback interface of the sandbox's network namespace so that
+// sandboxed commands may communicate with each other.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("configuring loopback: %w", err)
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return fmt.Errorf("configuring loopback: %w", err)
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return fmt.Errorf("configuring loopback: %w", err)
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq); err != nil {
+		return fmt.Errorf("configuring loopback: %w", err)
+	}
+	return nil
+}
+
+// shell runs the given shell program in the given directory. The program is interrupted if the
+// helper is interrupted.
+func shell(program, dir string) int {
+	file, err := syntax.NewParser().Parse(strings.NewReader(program), "")
+	if err != nil {
+		return fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	runner, err := interp.New(interp.Dir(dir), interp.StdIO(os.Stdin, os.Stdout, os.Stderr))
+	if err != nil {
+		return fail(err)
+	}
+	err = runner.Run(ctx, file)
+
+	var status interp.ExitStatus
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &status):
+		return int(status)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+}
+
+// accessed returns true if the file described by info has been accessed since its access time
+// was reset to the Unix epoch.
+func accessed(info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && (st.Atim.Sec != 0 || st.Atim.Nsec != 0)
+}
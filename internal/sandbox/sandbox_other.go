@@ -0,0 +1,19 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// sysProcAttr returns an error: sandboxes require Linux namespaces.
+func sysProcAttr() (*syscall.SysProcAttr, error) {
+	return nil, errors.New("sandboxing is only supported on Linux")
+}
+
+// accessed returns false: sandboxes are not supported on this platform.
+func accessed(_ fs.FileInfo) bool {
+	return false
+}
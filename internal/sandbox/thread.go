@@ -0,0 +1,16 @@
+package sandbox
+
+import "github.com/pgavlin/starlark-go/starlark"
+
+// SetThreadSandbox sets the sandbox in which the thread's commands must run. If s is nil, the
+// thread's commands are not sandboxed.
+func SetThreadSandbox(thread *starlark.Thread, s *Sandbox) {
+	thread.SetLocal("sandbox", s)
+}
+
+// ThreadSandbox returns the sandbox in which the thread's commands must run, or nil if the
+// thread's commands are not sandboxed.
+func ThreadSandbox(thread *starlark.Thread) *Sandbox {
+	s, _ := thread.Local("sandbox").(*Sandbox)
+	return s
+}
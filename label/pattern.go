@@ -0,0 +1,71 @@
+package label
+
+import (
+	"errors"
+	"strings"
+)
+
+// ParsePattern parses a label pattern. In addition to the labels accepted by Parse, a pattern
+// may use two wildcards:
+//
+//   - a package that ends with the component "..." matches that package and all of its
+//     subpackages, e.g. "//pkg/..." or "//..."
+//   - the name "*" matches all targets in the matched packages, e.g. "//pkg:*"
+//
+// A recursive pattern without a name matches all targets in the matched packages. Patterns
+// that contain wildcards only match targets, and so may not specify a kind.
+func ParsePattern(rawpattern string) (*Label, error) {
+	l, err := Parse(rawpattern)
+	if err != nil {
+		return nil, err
+	}
+
+	components := Split(l.Package)
+	for i, c := range components {
+		if c == "..." && i != len(components)-1 {
+			return nil, errors.New("'...' must be the last component of a pattern's package")
+		}
+	}
+	if l.Name != "*" && strings.ContainsRune(l.Name, '*') {
+		return nil, errors.New("'*' must be the entire name of a pattern")
+	}
+	if l.IsPattern() && l.Kind != "" {
+		return nil, errors.New("patterns may not specify a kind")
+	}
+	return l, nil
+}
+
+// IsPattern returns true if the label contains wildcards.
+func (l *Label) IsPattern() bool {
+	return l.Name == "*" || l.isRecursive()
+}
+
+func (l *Label) isRecursive() bool {
+	return l.Package == "..." || strings.HasSuffix(l.Package, "/...")
+}
+
+// Match returns true if the given target label is matched by the receiver. A label that does
+// not contain wildcards only matches itself. Patterns must be absolute in order to match any
+// labels.
+func (l *Label) Match(target *Label) bool {
+	if !l.IsPattern() {
+		return *l == *target
+	}
+
+	if !l.IsAbs() || target.Kind != "" || target.Project != l.Project {
+		return false
+	}
+	if l.Name != "" && l.Name != "*" && l.Name != target.Name {
+		return false
+	}
+	if !l.isRecursive() {
+		return target.Package == l.Package
+	}
+
+	base := strings.TrimSuffix(l.Package, "...")
+	if base == "//" {
+		return target.IsAbs()
+	}
+	base = strings.TrimSuffix(base, "/")
+	return target.Package == base || strings.HasPrefix(target.Package, base+"/")
+}
@@ -0,0 +1,114 @@
+package label
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePattern(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		input    string
+		expected *Label
+	}{
+		{
+			"//pkg:target",
+			&Label{Package: "//pkg", Name: "target"},
+		},
+		{
+			"//pkg:*",
+			&Label{Package: "//pkg", Name: "*"},
+		},
+		{
+			":*",
+			&Label{Name: "*"},
+		},
+		{
+			"//...",
+			&Label{Package: "//..."},
+		},
+		{
+			"//pkg/...",
+			&Label{Package: "//pkg/..."},
+		},
+		{
+			"//pkg/...:*",
+			&Label{Package: "//pkg/...", Name: "*"},
+		},
+		{
+			"//pkg/...:default",
+			&Label{Package: "//pkg/...", Name: "default"},
+		},
+		{
+			"...",
+			&Label{Package: "..."},
+		},
+		{
+			"source://pkg/...:file",
+			nil,
+		},
+		{
+			"//pkg/.../sub",
+			nil,
+		},
+		{
+			"//pkg:target*",
+			nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			t.Parallel()
+			l, err := ParsePattern(c.input)
+			if c.expected == nil {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, *c.expected, *l)
+			assert.Equal(t, c.input, l.String())
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		pattern string
+		label   string
+		matches bool
+	}{
+		{"//pkg:target", "//pkg:target", true},
+		{"//pkg:target", "//pkg:other", false},
+		{"//pkg:*", "//pkg:target", true},
+		{"//pkg:*", "//pkg/sub:target", false},
+		{"//pkg:*", "source://pkg:file", false},
+		{"//...", "//:target", true},
+		{"//...", "//pkg/sub:target", true},
+		{"//pkg/...", "//pkg:target", true},
+		{"//pkg/...", "//pkg/sub:target", true},
+		{"//pkg/...", "//pkgs:target", false},
+		{"//pkg/...", "//other:target", false},
+		{"//pkg/...:default", "//pkg/sub:default", true},
+		{"//pkg/...:default", "//pkg/sub:target", false},
+		{"...", "//pkg:target", false},
+	}
+	for _, c := range cases {
+		t.Run(c.pattern+" "+c.label, func(t *testing.T) {
+			t.Parallel()
+			pattern, err := ParsePattern(c.pattern)
+			if !assert.NoError(t, err) {
+				return
+			}
+			l, err := Parse(c.label)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.matches, pattern.Match(l))
+		})
+	}
+}
@@ -5,11 +5,17 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
+	"github.com/pgavlin/dawn/internal/sandbox"
 	"github.com/pgavlin/dawn/util"
 	"github.com/pgavlin/starlark-go/starlark"
 )
 
+// waitDelay bounds the time spent waiting for an interrupted process to exit and for its output
+// to close.
+const waitDelay = 5 * time.Second
+
 // starlark
 //
 //	def look_path(file):
@@ -72,7 +78,7 @@ func execf(
 	}
 	cmd.Stdout, cmd.Stderr = util.Stdio(thread)
 
-	if err = cmd.Run(); err != nil {
+	if err = run(thread, cmd); err != nil {
 		return nil, fmt.Errorf("%v: %w", fn.Name(), err)
 	}
 	return starlark.None, nil
@@ -118,7 +124,7 @@ func output(
 	cmd.Stdout = &stdout
 	_, cmd.Stderr = util.Stdio(thread)
 
-	if err := cmd.Run(); err != nil {
+	if err := run(thread, cmd); err != nil {
 		return nil, fmt.Errorf("%v: %w", fn.Name(), err)
 	}
 
@@ -161,10 +167,29 @@ func command(
 		env = pairs
 	}
 
+	// The process is interrupted if the thread's context is cancelled, e.g. because the calling
+	// target timed out, and killed if it has not exited once waitDelay elapses.
+	//
 	//nolint:gosec
-	cmd := exec.Command(command[0], command[1:]...)
+	cmd := exec.CommandContext(util.GetContext(thread), command[0], command[1:]...)
 	cmd.Dir = cwd
 	cmd.Env = env
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = waitDelay
 
 	return cmd, nil
 }
+
+// run runs the given command. If the thread's commands are sandboxed, the command runs inside
+// the thread's sandbox.
+func run(thread *starlark.Thread, cmd *exec.Cmd) error {
+	if s := sandbox.ThreadSandbox(thread); s != nil {
+		return s.Run(cmd)
+	}
+	return cmd.Run()
+}
@@ -1,12 +1,12 @@
 package sh
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
+	"github.com/pgavlin/dawn/internal/sandbox"
 	"github.com/pgavlin/dawn/util"
 	"github.com/pgavlin/starlark-go/starlark"
 
@@ -38,16 +38,15 @@ import (
 //
 //starlark:builtin factory=NewExec,function=Exec
 func exec(thread *starlark.Thread, fn *starlark.Builtin, cmd, cwd string, env starlark.IterableMapping, try bool) (starlark.Value, error) {
-	file, options, try, err := command(thread, cmd, cwd, env, try)
+	file, dir, environ, err := command(thread, cmd, cwd, env)
 	if err != nil {
 		return nil, err
 	}
 
 	stdout, stderr := util.Stdio(thread)
-	options = append(options, interp.StdIO(nil, stdout, stderr))
 
 	fmt.Fprintln(stdout, cmd)
-	if err := run(context.Background(), file, options); err != nil {
+	if err := run(thread, cmd, file, dir, environ, stdout, stderr); err != nil {
 		if try {
 			return starlark.String(err.Error()), nil
 		}
@@ -81,7 +80,7 @@ func exec(thread *starlark.Thread, fn *starlark.Builtin, cmd, cwd string, env st
 //
 //starlark:builtin factory=NewOutput,function=Output
 func output(thread *starlark.Thread, fn *starlark.Builtin, cmd, cwd string, env starlark.IterableMapping, try bool) (starlark.Value, error) {
-	file, options, try, err := command(thread, cmd, cwd, env, try)
+	file, dir, environ, err := command(thread, cmd, cwd, env)
 	if err != nil {
 		return nil, err
 	}
@@ -91,10 +90,9 @@ func output(thread *starlark.Thread, fn *starlark.Builtin, cmd, cwd string, env
 	if try {
 		stderr = io.Discard
 	}
-	options = append(options, interp.StdIO(nil, &stdout, stderr))
 
 	fmt.Fprintln(threadStdout, cmd)
-	if err := run(context.Background(), file, options); err != nil {
+	if err := run(thread, cmd, file, dir, environ, &stdout, stderr); err != nil {
 		if try {
 			return starlark.Tuple{starlark.None, starlark.String(err.Error())}, nil
 		}
@@ -108,22 +106,24 @@ func output(thread *starlark.Thread, fn *starlark.Builtin, cmd, cwd string, env
 	return out, nil
 }
 
-func command(thread *starlark.Thread, command, cwd string, env starlark.IterableMapping, try bool) (*syntax.File, []interp.RunnerOption, bool, error) {
+// command parses the given shell command and returns its working directory and environment. If
+// no environment variables are given, the environment is nil, and the command inherits the
+// environment of the current process.
+func command(thread *starlark.Thread, command, cwd string, env starlark.IterableMapping) (*syntax.File, string, []string, error) {
 	file, err := syntax.NewParser().Parse(strings.NewReader(command), "")
 	if err != nil {
-		return nil, nil, false, err
+		return nil, "", nil, err
 	}
 
-	var options []interp.RunnerOption
 	if cwd == "" {
 		cwd = util.Getwd(thread)
 	}
-	options = append(options, interp.Dir(cwd))
 
+	var environ []string
 	if env != nil {
 		items := env.Items()
 
-		environ := os.Environ()
+		environ = os.Environ()
 		pairs := make([]string, 0, len(environ)+len(items))
 		pairs = append(pairs, environ...)
 		for _, kvp := range items {
@@ -138,14 +138,24 @@ func command(thread *starlark.Thread, command, cwd string, env starlark.Iterable
 
 			pairs = append(pairs, fmt.Sprintf("%v=%v", key, value))
 		}
-
-		options = append(options, interp.Env(expand.ListEnviron(pairs...)))
+		environ = pairs
 	}
 
-	return file, options, try, nil
+	return file, cwd, environ, nil
 }
 
-func run(ctx context.Context, file *syntax.File, options []interp.RunnerOption) error {
+// run runs the given parsed shell command. If the thread's commands are sandboxed, the command
+// runs inside the thread's sandbox.
+func run(thread *starlark.Thread, command string, file *syntax.File, dir string, environ []string, stdout, stderr io.Writer) error {
+	ctx := util.GetContext(thread)
+	if s := sandbox.ThreadSandbox(thread); s != nil {
+		return s.RunShell(ctx, command, dir, environ, stdout, stderr)
+	}
+
+	options := []interp.RunnerOption{interp.Dir(dir), interp.StdIO(nil, stdout, stderr)}
+	if environ != nil {
+		options = append(options, interp.Env(expand.ListEnviron(environ...)))
+	}
 	runner, err := interp.New(options...)
 	if err != nil {
 		return err
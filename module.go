@@ -3,6 +3,7 @@ package dawn
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"slices"
 	"sync"
@@ -31,6 +32,13 @@ type module struct {
 	data   starlark.StringDict
 	err    error
 
+	// The module's inputs, which are recorded in the project's index.
+	sum   string             // the SHA-256 sum of the module's source
+	globs []globInput        // the results of the module's calls to glob
+	flags map[string]*string // the raw arguments of the flags parsed by the module, or nil if unset
+
+	program string // the key of the module's compiled program
+
 	out *lineWriter
 }
 
@@ -48,6 +56,29 @@ func (m *module) setLoading(other *module) {
 	m.m.Unlock()
 }
 
+// recordGlob records the results of a call to glob made while the module is loading.
+func (m *module) recordGlob(g globInput) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if !m.loaded {
+		m.globs = append(m.globs, g)
+	}
+}
+
+// recordFlag records the raw argument of a flag parsed while the module is loading.
+func (m *module) recordFlag(name string, raw *string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if !m.loaded {
+		if m.flags == nil {
+			m.flags = map[string]*string{}
+		}
+		m.flags[name] = raw
+	}
+}
+
 // done marks the receiver as done.
 func (m *module) done(data starlark.StringDict, err error) (starlark.StringDict, error) {
 	m.data, m.err = data, err
@@ -130,6 +161,7 @@ func (m *module) env(proj *Project) (*starlark.Thread, starlark.StringDict, erro
 	builtins["target"] = proj.newBuiltin_target()
 	builtins["glob"] = proj.newBuiltin_glob()
 	builtins["fail"] = proj.newBuiltin_fail()
+	builtins["require"] = proj.newBuiltin_require()
 
 	builtins["package"] = starlark.String(m.label.Package)
 
@@ -172,7 +204,24 @@ func (m *module) load(ctx context.Context, proj *Project) (starlark.StringDict,
 	done := util.SetContext(ctx, t)
 	defer done()
 
-	v, err := m.done(starlark.ExecFile(t, m.path, nil, builtins))
+	//nolint:gosec
+	src, err := os.ReadFile(m.path)
+	if err != nil {
+		proj.events.ModuleLoadFailed(m.label, err)
+		return m.done(nil, err)
+	}
+	m.sum = moduleSum(src)
+
+	prog, err := m.compile(proj, src, builtins)
+	if err != nil {
+		proj.events.ModuleLoadFailed(m.label, err)
+		return m.done(nil, err)
+	}
+
+	globals, err := prog.Init(t, builtins)
+	globals.Freeze()
+
+	v, err := m.done(globals, err)
 	if err != nil {
 		proj.events.ModuleLoadFailed(m.label, err)
 		return nil, err
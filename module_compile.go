@@ -0,0 +1,249 @@
+package dawn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pgavlin/starlark-go/resolve"
+	"github.com/pgavlin/starlark-go/starlark"
+	"github.com/pgavlin/starlark-go/syntax"
+	"github.com/sugawarayuuta/sonnet"
+)
+
+// A compiledProgram is a module's compiled program along with the sources of the functions it
+// defines.
+type compiledProgram struct {
+	program   *starlark.Program
+	functions map[string]functionSource // keyed by the position of each function's def or lambda
+}
+
+// A functionSource records the source of a function defined by a module.
+//
+// Programs that are decoded from their serialized form do not record the names, constants,
+// globals, and nested functions that each of their functions references, so each function's
+// environment is computed from its source instead. This keeps the environments of functions
+// in decoded programs identical to those of functions in freshly-compiled programs.
+type functionSource struct {
+	// Sum is the SHA-256 sum of the lines that define the function.
+	Sum string `json:"sum"`
+	// Globals holds the names of the module globals referenced by the function and its nested
+	// functions.
+	Globals []string `json:"globals,omitempty"`
+}
+
+// A programEntry is the on-disk form of a compiled program.
+type programEntry struct {
+	// Program is the base64-encoded serialized program.
+	Program string `json:"program"`
+	// Functions holds the sources of the program's functions, keyed by the line and column of
+	// each function's def or lambda.
+	Functions map[string]functionSource `json:"functions"`
+}
+
+// programKey returns the key of the compiled program for the module at the given path with the
+// given source and predeclared names. Compiled programs record the path of their source file and
+// depend on the set of predeclared names and the version of the compiler, so each of these
+// contributes to the key.
+func programKey(path string, src []byte, predeclared starlark.StringDict) string {
+	h := sha256.New()
+	_ = binary.Write(h, binary.LittleEndian, int64(starlark.CompilerVersion))
+	for _, s := range append([]string{path}, slices.Sorted(maps.Keys(predeclared))...) {
+		_ = binary.Write(h, binary.LittleEndian, int64(len(s)))
+		h.Write([]byte(s))
+	}
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// programPath returns the path of the on-disk entry for the compiled program with the given key.
+func (proj *Project) programPath(key string) string {
+	return filepath.Join(proj.work, "programs", key[:2], key)
+}
+
+// compile returns the compiled program for the module's source. Compiled programs are retained
+// across reloads and cached on disk under .dawn/build/programs, so a module whose source is
+// unchanged is not parsed or compiled again.
+func (m *module) compile(proj *Project, src []byte, predeclared starlark.StringDict) (*starlark.Program, error) {
+	m.program = programKey(m.path, src, predeclared)
+
+	proj.m.Lock()
+	prog, ok := proj.programs[m.program]
+	proj.m.Unlock()
+	if !ok {
+		prog, ok = proj.loadProgram(m.program)
+	}
+	if !ok {
+		f, program, err := starlark.SourceProgram(m.path, src, predeclared.Has)
+		if err != nil {
+			return nil, err
+		}
+		prog = &compiledProgram{program: program, functions: functionSources(f, src)}
+
+		// Failing to cache the program only costs a compilation the next time the module is
+		// loaded.
+		_ = proj.saveProgram(m.program, prog)
+	}
+
+	proj.m.Lock()
+	proj.addProgram(m.program, prog)
+	proj.m.Unlock()
+
+	return prog.program, nil
+}
+
+// loadProgram loads the compiled program with the given key from disk. Entries that cannot be
+// read or decoded are treated as misses.
+func (proj *Project) loadProgram(key string) (*compiledProgram, bool) {
+	//nolint:gosec
+	f, err := os.Open(proj.programPath(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var entry programEntry
+	if err := sonnet.NewDecoder(f).Decode(&entry); err != nil {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(entry.Program)
+	if err != nil {
+		return nil, false
+	}
+	program, err := starlark.CompiledProgram(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	return &compiledProgram{program: program, functions: entry.Functions}, true
+}
+
+// saveProgram writes the compiled program with the given key to disk.
+func (proj *Project) saveProgram(key string, prog *compiledProgram) error {
+	var buf bytes.Buffer
+	if err := prog.program.Write(&buf); err != nil {
+		return err
+	}
+	entry := programEntry{
+		Program:   base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Functions: prog.functions,
+	}
+
+	path := proj.programPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(proj.temp, 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(proj.temp, "")
+	if err != nil {
+		return err
+	}
+	tempName := f.Name()
+
+	if err = sonnet.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	return os.Rename(tempName, path)
+}
+
+// addProgram records the given compiled program and the sources of its functions. proj.m must be
+// held.
+func (proj *Project) addProgram(key string, prog *compiledProgram) {
+	proj.programs[key] = prog
+
+	filename := prog.program.Filename()
+	for pos, source := range prog.functions {
+		proj.functions[filename+":"+pos] = source
+	}
+}
+
+// functionSource returns the source of the function with the given code, if known.
+func (proj *Project) functionSource(code *starlark.FunctionCode) (functionSource, bool) {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	source, ok := proj.functions[code.Position().String()]
+	return source, ok
+}
+
+// functionSources returns the sources of the functions defined by the given resolved file, keyed
+// by the line and column of each function's def or lambda.
+func functionSources(f *syntax.File, src []byte) map[string]functionSource {
+	lines := bytes.SplitAfter(src, []byte("\n"))
+
+	sources := map[string]functionSource{}
+	syntax.Walk(f, func(n syntax.Node) bool {
+		var fn *resolve.Function
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			fn, _ = n.Function.(*resolve.Function)
+		case *syntax.LambdaExpr:
+			fn, _ = n.Function.(*resolve.Function)
+		}
+		if fn == nil {
+			return true
+		}
+
+		// The function's source spans the lines from its def or lambda through the end of its
+		// body.
+		h := sha256.New()
+		end := syntax.End(fn.Body[len(fn.Body)-1]).Line
+		for line := fn.Pos.Line; line >= 1 && line <= end && int(line) <= len(lines); line++ {
+			h.Write(lines[line-1])
+		}
+
+		globals := map[string]bool{}
+		for _, stmt := range fn.Body {
+			syntax.Walk(stmt, func(n syntax.Node) bool {
+				if id, ok := n.(*syntax.Ident); ok {
+					if b, ok := id.Binding.(*resolve.Binding); ok && b.Scope == resolve.Global {
+						globals[id.Name] = true
+					}
+				}
+				return true
+			})
+		}
+
+		sources[fmt.Sprintf("%d:%d", fn.Pos.Line, fn.Pos.Col)] = functionSource{
+			Sum:     hex.EncodeToString(h.Sum(nil)),
+			Globals: slices.Sorted(maps.Keys(globals)),
+		}
+		return true
+	})
+	return sources
+}
+
+// prunePrograms discards the compiled programs that are not used by any of the project's modules.
+// The on-disk entries for unused programs are removed by GC.
+func (proj *Project) prunePrograms() {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	used := make(map[string]bool, len(proj.modules))
+	for _, m := range proj.modules {
+		used[m.program] = true
+	}
+
+	programs := proj.programs
+	proj.programs, proj.functions = map[string]*compiledProgram{}, map[string]functionSource{}
+	for key, prog := range programs {
+		if used[key] {
+			proj.addProgram(key, prog)
+		}
+	}
+}
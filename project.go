@@ -5,13 +5,13 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"maps"
 	"os"
 	"path/filepath"
-	"runtime"
 	"slices"
 	"strings"
 	"sync"
@@ -20,16 +20,15 @@ import (
 	"github.com/mitchellh/go-homedir"
 	"github.com/pgavlin/dawn/internal/mvs"
 	"github.com/pgavlin/dawn/internal/project"
+	"github.com/pgavlin/dawn/internal/sandbox"
 	"github.com/pgavlin/dawn/internal/spell"
 	"github.com/pgavlin/dawn/label"
 	"github.com/pgavlin/dawn/runner"
 	"github.com/pgavlin/dawn/util"
 	"github.com/pgavlin/fx/v2"
-	fxs "github.com/pgavlin/fx/v2/slices"
 	"github.com/pgavlin/glob"
 	"github.com/pgavlin/starlark-go/starlark"
 	"github.com/pgavlin/starlark-go/syntax"
-	"github.com/rjeczalik/notify"
 	"github.com/sugawarayuuta/sonnet"
 )
 
@@ -48,11 +47,15 @@ type Project struct {
 	args   []string
 	events Events
 
-	root string
-	work string
-	temp string
+	root   string
+	work   string
+	temp   string
+	config string
 
-	ignore glob.Glob
+	ignore    glob.Glob
+	cache     project.CacheConfig
+	resources map[string]int
+	sandbox   bool // true if every target's processes are sandboxed
 
 	configPath   string
 	resolver     *mvs.Resolver
@@ -60,10 +63,30 @@ type Project struct {
 	requirements map[string]string // maps project name to project path. local to each module.
 	buildList    map[string]string // maps project path to version
 
-	builtins starlark.StringDict
+	builtins  starlark.StringDict
+	programs  map[string]*compiledProgram // maps program keys to compiled programs. retained across reloads.
+	functions map[string]functionSource   // maps function positions to the sources of the functions
 
-	always bool
-	dryrun bool
+	sums    *fileSumCache
+	actions *actionCache
+
+	always    bool
+	dryrun    bool
+	keepGoing bool
+	schedule  string
+	report    *runReport
+
+	lazy     bool
+	packages map[string]*lazyPackage // the packages that have been required by a lazy project
+
+	generators map[string]string // maps generated source files to their generators as of the last run
+
+	watching bool        // true if the project is being watched
+	services *serviceSet // the project's running services
+
+	audits  map[string]*sandbox.Audit // the audit of each target while checking hermeticity
+	scratch string                    // the directory that holds audited outputs while checking hermeticity
+	skip    func(string, bool) bool   // returns true for the files that audited processes never see
 
 	flags   map[string]*Flag
 	modules map[string]*module
@@ -79,6 +102,23 @@ type LoadOptions struct {
 	Builtins starlark.StringDict
 
 	PreferIndex bool
+
+	// Lazy defers loading each package until one of its targets is required, e.g. in order to
+	// build a target or one of its dependents. Commands that need the complete target graph
+	// should not load lazily. A lazy project finds the generator of a source file in the
+	// package that contains the file or in the package recorded for the file by an earlier
+	// run. If neither defines a generator and the file does not exist, every package is loaded.
+	Lazy bool
+
+	// ActionCache is the path to the directory that holds the action cache. If empty, the
+	// action cache is stored in ~/.dawn/cache.
+	ActionCache string
+	// RemoteCache is the URL of a remote HTTP cache that backs the action cache. If set, it
+	// overrides the remote cache configured in the project's dawn.toml.
+	RemoteCache string
+	// RemoteCacheMode is the mode of the remote cache, either "read-only" or "write-through". If
+	// set, it overrides the mode configured in the project's dawn.toml.
+	RemoteCacheMode string
 }
 
 func (options *LoadOptions) apply(p *Project, preferIndex *bool) {
@@ -87,6 +127,11 @@ func (options *LoadOptions) apply(p *Project, preferIndex *bool) {
 		p.builtins = options.Builtins
 		p.events = options.Events
 		*preferIndex = options.PreferIndex
+		p.lazy = options.Lazy
+		if options.ActionCache != "" {
+			p.actions = newActionCache(options.ActionCache)
+		}
+		p.cache = project.CacheConfig{Remote: options.RemoteCache, Mode: options.RemoteCacheMode}
 	}
 	if p.events == nil {
 		p.events = DiscardEvents
@@ -105,6 +150,10 @@ func Load(ctx context.Context, root string, options *LoadOptions) (proj *Project
 		work:        filepath.Join(root, ".dawn", "build"),
 		temp:        filepath.Join(root, ".dawn", "build", "temp"),
 		moduleCache: moduleCache,
+		packages:    map[string]*lazyPackage{},
+		programs:    map[string]*compiledProgram{},
+		functions:   map[string]functionSource{},
+		services:    newServiceSet(),
 		flags:       map[string]*Flag{},
 		modules:     map[string]*module{},
 		targets:     map[string]*runTarget{},
@@ -112,12 +161,21 @@ func Load(ctx context.Context, root string, options *LoadOptions) (proj *Project
 	preferIndex := false
 	options.apply(proj, &preferIndex)
 
+	proj.sums = loadFileSumCache(filepath.Join(proj.work, "sums.json"))
+	proj.loadGenerators()
+	if proj.actions == nil {
+		proj.actions = newActionCache(filepath.Join(home, ".dawn", "cache"))
+	}
+
 	proj.resolver = mvs.NewResolver(moduleCache, mvs.DefaultDialer, resolveEvents{proj.events})
 	if err := proj.loadConfig(); err != nil {
 		return nil, err
 	}
+	if proj.actions.remote, err = newRemoteCache(proj.cache.Remote, proj.cache.Mode); err != nil {
+		return nil, fmt.Errorf("configuring remote cache: %w", err)
+	}
 
-	proj.runner = runner.NewRunner(proj, runtime.NumCPU())
+	proj.runner = runner.NewRunner(proj, proj.resources)
 
 	if err := proj.load(ctx, preferIndex); err != nil {
 		return nil, err
@@ -140,7 +198,12 @@ func (proj *Project) load(ctx context.Context, index bool) (err error) {
 		return err
 	}
 
-	if err = proj.loadPackage(ctx, nil, "//"); err != nil {
+	if proj.lazy {
+		err = proj.loadLazy(ctx)
+	} else {
+		err = proj.loadPackage(ctx, "//")
+	}
+	if err != nil {
 		return err
 	}
 	for _, m := range proj.modules {
@@ -152,11 +215,30 @@ func (proj *Project) load(ctx context.Context, index bool) (err error) {
 	if err := proj.link(); err != nil {
 		return err
 	}
+	proj.prunePrograms()
+
+	// Now that all flags have been parsed, load each target's information for the current
+	// configuration.
+	proj.config = configHash(proj.flags)
+	for _, t := range proj.targets {
+		if err := t.target.load(); err != nil {
+			return err
+		}
+		// Seed the target's data so that a target whose result is retained by the runner across
+		// reloads presents its last recorded data to its dependents.
+		t.data = t.target.info().Data
+	}
 
+	// The index of a lazy project would only describe the packages that have been loaded.
+	if proj.lazy {
+		return nil
+	}
 	return proj.saveIndex()
 }
 
 func (proj *Project) Reload(ctx context.Context) (err error) {
+	proj.config = ""
+	proj.packages = map[string]*lazyPackage{}
 	proj.flags = map[string]*Flag{}
 	proj.modules = map[string]*module{}
 	proj.targets = map[string]*runTarget{}
@@ -166,37 +248,127 @@ func (proj *Project) Reload(ctx context.Context) (err error) {
 type RunOptions struct {
 	Always bool
 	DryRun bool
+
+	// KeepGoing causes the run to continue building every target that does not depend on a
+	// failed target. If any targets fail, Run returns a *RunError that describes each failure.
+	KeepGoing bool
+
+	// Schedule is the policy used to order targets that are ready to run, either
+	// runner.ScheduleCriticalPath or runner.ScheduleFIFO. The critical-path policy, which is the
+	// default, prioritizes the targets on the longest remaining path as estimated from the
+	// durations of previous runs.
+	Schedule string
 }
 
 func (opts *RunOptions) apply(proj *Project) {
 	if opts == nil {
 		proj.always = false
 		proj.dryrun = false
+		proj.keepGoing = false
+		proj.schedule = ""
 		return
 	}
 
 	proj.always = opts.Always
 	proj.dryrun = opts.DryRun
+	proj.keepGoing = opts.KeepGoing
+	proj.schedule = opts.Schedule
 }
 
-func (proj *Project) Run(ctx context.Context, label *label.Label, options *RunOptions) error {
+// Run builds the targets with the given labels. Labels may be patterns (see label.ParsePattern),
+// which are expanded to the labels of the targets they match. All targets are built in a single
+// pass, so targets that are shared by multiple dependents are only evaluated once.
+func (proj *Project) Run(ctx context.Context, labels []*label.Label, options *RunOptions) error {
 	options.apply(proj)
+	proj.report = newRunReport()
+
+	// Load the packages that may contain the targets matched by any patterns.
+	for _, l := range labels {
+		if err := proj.requirePattern(ctx, l); err != nil {
+			proj.events.RunDone(err)
+			return err
+		}
+	}
 
-	err := proj.runner.Run(ctx, label.String())
+	targets, err := proj.expand(labels)
+	if err != nil {
+		proj.events.RunDone(err)
+		return err
+	}
+
+	err = proj.runner.Run(ctx, targets, &runner.Options{KeepGoing: proj.keepGoing, Schedule: proj.schedule})
+	if err != nil && proj.keepGoing {
+		if runErr := proj.report.err(); runErr != nil {
+			err = runErr
+		}
+	}
+	err = errors.Join(err, proj.sums.save(proj.temp), proj.saveGenerators())
 	proj.events.RunDone(err)
+
+	// Outside of Watch, services run until they exit or the run is cancelled. If the run failed,
+	// the services are stopped immediately.
+	if !proj.watching {
+		if err == nil {
+			proj.services.wait(ctx)
+		}
+		proj.services.stopAll()
+	}
 	return err
 }
 
-func (proj *Project) Metrics() (running, waiting int) {
+// expand returns the labels of the targets referenced by the given labels. Patterns are replaced
+// with the labels of the targets they match.
+func (proj *Project) expand(labels []*label.Label) ([]string, error) {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	var targets []string
+	seen := map[string]bool{}
+	add := func(l string) {
+		if !seen[l] {
+			seen[l] = true
+			targets = append(targets, l)
+		}
+	}
+
+	for _, l := range labels {
+		if !l.IsPattern() {
+			add(l.String())
+			continue
+		}
+
+		var matches []string
+		for name, t := range proj.targets {
+			if l.Match(t.target.Label()) {
+				matches = append(matches, name)
+			}
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no targets match %v", l)
+		}
+
+		slices.Sort(matches)
+		for _, m := range matches {
+			add(m)
+		}
+	}
+	return targets, nil
+}
+
+// Metrics returns the current state of the project's resource pools.
+func (proj *Project) Metrics() runner.Metrics {
 	return proj.runner.Metrics()
 }
 
 // LoadTarget implements runner.Host.
-func (proj *Project) LoadTarget(_ context.Context, rawlabel string) (runner.Target, error) {
+func (proj *Project) LoadTarget(ctx context.Context, rawlabel string) (runner.Target, error) {
 	l, err := label.Parse(rawlabel)
 	if err != nil {
 		return nil, err
 	}
+	if err := proj.requireLabel(ctx, l); err != nil {
+		return nil, err
+	}
 
 	proj.m.Lock()
 	defer proj.m.Unlock()
@@ -208,85 +380,127 @@ func (proj *Project) LoadTarget(_ context.Context, rawlabel string) (runner.Targ
 	return target, nil
 }
 
-func (proj *Project) Watch(ctx context.Context, label *label.Label) error {
+// EstimateDuration implements runner.DurationEstimator. A target's estimated duration is the
+// duration of its last evaluation.
+func (proj *Project) EstimateDuration(rawlabel string) time.Duration {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	if target, ok := proj.targets[rawlabel]; ok {
+		return target.target.info().Duration
+	}
+	return 0
+}
+
+// WatchOptions configures the way in which Watch detects changes to a project's files.
+type WatchOptions struct {
+	// Poll causes Watch to detect changes by periodically examining the project's module files,
+	// its sources, and the directories that contain them rather than by using the platform's
+	// file watcher. Watch also polls if the platform's file watcher cannot be started.
+	Poll bool
+
+	// PollInterval is the interval at which Watch polls for changes. If PollInterval is zero,
+	// DefaultPollInterval is used.
+	PollInterval time.Duration
+}
+
+func (proj *Project) Watch(ctx context.Context, labels []*label.Label) error {
+	return proj.WatchWithOptions(ctx, labels, nil)
+}
+
+// WatchWithOptions is like Watch, but detects changes as configured by the given options. If the
+// options are nil, the platform's file watcher is used.
+func (proj *Project) WatchWithOptions(ctx context.Context, labels []*label.Label, options *WatchOptions) error {
+	if options == nil {
+		options = &WatchOptions{}
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	events := make(chan notify.EventInfo, 1000)
-	eventsDone := make(chan struct{})
-	go func() {
-		builds := make(chan struct{})
-		buildsDone := make(chan struct{})
+	// Services keep running between builds and are stopped once watching ends.
+	proj.watching = true
+	defer func() {
+		proj.watching = false
+		proj.services.stopAll()
+	}()
 
-		go func() {
-			for range builds {
-				if err := proj.Reload(ctx); err != nil {
-					// Project's load events are responsible for logging the error.
-					continue
-				}
+	w := proj.newWatcher(options)
+	defer w.Close()
 
-				// Project's run events are responsible for logging the error.
-				_ = proj.Run(ctx, label, nil)
+	builds := make(chan map[string]struct{})
+	buildsDone := make(chan struct{})
+	go func() {
+		// Changes accumulate until the project reloads successfully. The snapshot is taken
+		// before the first reload so that it describes the last successfully-loaded project.
+		var snapshot *projectSnapshot
+		pending := map[string]struct{}{}
+		for changed := range builds {
+			if snapshot == nil {
+				snapshot = proj.snapshot()
 			}
-			close(buildsDone)
-		}()
+			maps.Copy(pending, changed)
 
-		defer func() {
-			close(builds)
-			<-buildsDone
+			if err := proj.reloadChanged(ctx, pending); err != nil {
+				// Project's load events are responsible for logging the error.
+				continue
+			}
 
-			close(eventsDone)
-		}()
+			// Only re-evaluate the targets that were affected by the changes.
+			proj.invalidate(snapshot, pending)
+			proj.stopRemovedServices()
+			snapshot, pending = nil, map[string]struct{}{}
 
-		dirty := false
-		rate := time.NewTicker(500 * time.Millisecond)
-		for {
-			select {
-			case event, ok := <-events:
-				if !ok {
-					return
-				}
+			// Project's run events are responsible for logging the error.
+			_ = proj.Run(ctx, labels, nil)
+		}
+		close(buildsDone)
+	}()
 
-				rel, err := filepath.Rel(proj.root, event.Path())
-				if err != nil {
-					continue
-				}
+	defer func() {
+		close(builds)
+		<-buildsDone
+	}()
+
+	changed := map[string]struct{}{}
+	rate := time.NewTicker(500 * time.Millisecond)
+	defer rate.Stop()
+	for {
+		select {
+		case path, ok := <-w.Changes():
+			if !ok {
+				return ctx.Err()
+			}
 
-				if !strings.HasPrefix(event.Path(), proj.work) && !proj.ignored(rel) {
-					dirty = true
+			rel, err := filepath.Rel(proj.root, path)
+			if err != nil {
+				continue
+			}
 
-					label, err := sourceLabel("//", rel)
-					if err != nil {
-						continue
-					}
-					proj.events.FileChanged(label)
-				}
+			if !strings.HasPrefix(path, proj.work) && !proj.ignored(rel) {
+				changed[path] = struct{}{}
 
-			case <-rate.C:
-				if dirty {
-					select {
-					case builds <- struct{}{}:
-						dirty = false
-					default:
-						// Loop around
-					}
+				label, err := sourceLabel("//", rel)
+				if err != nil {
+					continue
 				}
+				proj.events.FileChanged(label)
+			}
 
-			case <-ctx.Done():
-				close(builds)
+		case <-rate.C:
+			if len(changed) != 0 {
+				select {
+				case builds <- changed:
+					changed = map[string]struct{}{}
+				default:
+					// Loop around
+				}
 			}
-		}
-	}()
 
-	if err := notify.Watch(filepath.Join(proj.root, "..."), events, notify.All); err != nil {
-		close(events)
-		<-eventsDone
-		return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-
-	// Never terminates
-	<-eventsDone
-	return ctx.Err()
 }
 
 func (proj *Project) REPLEnv(stdout io.Writer, pkg *label.Label) (thread *starlark.Thread, globals starlark.StringDict) {
@@ -305,6 +519,11 @@ func (proj *Project) REPLEnv(stdout io.Writer, pkg *label.Label) (thread *starla
 }
 
 func (proj *Project) GC() error {
+	// A lazy project does not know about the targets in packages it has not loaded.
+	if proj.lazy {
+		return errors.New("cannot collect garbage in a lazily-loaded project")
+	}
+
 	// collect all of the info paths referenced by this project
 	paths := map[string]struct{}{}
 
@@ -320,10 +539,16 @@ func (proj *Project) GC() error {
 	}
 
 	markPath(filepath.Join(proj.work, "index.json"))
+	markPath(filepath.Join(proj.work, "sums.json"))
 	markPath(filepath.Join(proj.work, "temp"))
 	for _, t := range proj.targets {
 		markPath(proj.targetInfoPath(t.target.Label()))
 	}
+	for _, m := range proj.modules {
+		if m.program != "" {
+			markPath(proj.programPath(m.program))
+		}
+	}
 
 	return filepath.WalkDir(proj.work, func(path string, d fs.DirEntry, err error) error {
 		if os.IsNotExist(err) {
@@ -333,6 +558,11 @@ func (proj *Project) GC() error {
 			return err
 		}
 
+		// Leave the information for other configurations intact.
+		if d.IsDir() && filepath.Dir(path) == proj.work && isConfigDir(d.Name()) && d.Name() != proj.config {
+			return fs.SkipDir
+		}
+
 		if _, ok := paths[path]; !ok {
 			err := os.RemoveAll(path)
 			if err != nil && !os.IsNotExist(err) {
@@ -362,6 +592,10 @@ func (proj *Project) Flags() []*Flag {
 }
 
 func (proj *Project) Target(label *label.Label) (Target, error) {
+	if err := proj.requireLabel(context.TODO(), label); err != nil {
+		return nil, err
+	}
+
 	proj.m.Lock()
 	defer proj.m.Unlock()
 
@@ -421,6 +655,9 @@ type targetInfo struct {
 	Dependencies map[string]string `json:"dependencies,omitempty"`
 	Data         string            `json:"stamp,omitempty"`
 	Rerun        bool              `json:"rerun,omitempty"`
+	Duration     time.Duration     `json:"duration,omitempty"`   // the wall-clock duration of the target's last evaluation
+	Discovered   map[string]string `json:"discovered,omitempty"` // the sums of the sources listed in the target's depfile
+	Required     []string          `json:"required,omitempty"`   // the labels of the targets required by the target's last evaluation
 }
 
 func (proj *Project) targetInfoPath(l *label.Label) string {
@@ -434,7 +671,39 @@ func (proj *Project) targetInfoPath(l *label.Label) string {
 	}
 
 	pathSum := sha256.Sum256([]byte(l.Package[2:] + "/" + target))
-	return filepath.Join(proj.work, kind+"s", hex.EncodeToString(pathSum[:]))
+	return filepath.Join(proj.work, proj.config, kind+"s", hex.EncodeToString(pathSum[:]))
+}
+
+// configHashLen is the length of a configuration hash.
+const configHashLen = 16
+
+// configHash returns the hash of the configuration described by the given flags. Target
+// information is stored separately for each configuration so that switching between
+// configurations does not invalidate the targets built in each. Only flags that are not set to
+// their default values contribute to the hash so that the hash does not depend on which
+// packages have been loaded.
+func configHash(flags map[string]*Flag) string {
+	h := sha256.New()
+	for _, name := range slices.Sorted(maps.Keys(flags)) {
+		value := "None"
+		if v := flags[name].Value; v != nil {
+			value = v.String()
+		}
+		if def := flags[name].Default; value == def || def == "" && value == "None" {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", name, value)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:configHashLen]
+}
+
+// isConfigDir returns true if the given name is the name of a configuration's directory.
+func isConfigDir(name string) bool {
+	if len(name) != configHashLen {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
 }
 
 func (proj *Project) loadTargetInfo(label *label.Label) (targetInfo, error) {
@@ -484,43 +753,24 @@ func (proj *Project) saveTargetInfo(label *label.Label, info targetInfo) error {
 	return os.Rename(tempName, path)
 }
 
+// relPath returns the slash-separated, project-relative form of the given absolute path.
+func (proj *Project) relPath(path string) string {
+	if rel, err := filepath.Rel(proj.root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
 func (proj *Project) ignored(path string) bool {
 	path = filepath.ToSlash(path)
 	return proj.ignore != nil && proj.ignore.MatchPath(path)
 }
 
-func (proj *Project) loadPackage(ctx context.Context, wg *sync.WaitGroup, path string) error {
-	if proj.ignored(path[2:]) {
-		return nil
-	}
-
-	if wg == nil {
-		wg = &sync.WaitGroup{}
-		defer wg.Wait()
-	}
-
-	dir := filepath.Join(proj.root, path[2:])
-
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return err
-	}
-	if slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == "BUILD.dawn" }) {
-		wg.Add(1)
-		go func() {
-			_, _ = proj.loadModule(ctx, nil, &label.Label{Kind: "module", Package: path, Name: "BUILD.dawn"})
-			wg.Done()
-		}()
-	}
-	dirs := fxs.Filter(entries, func(e os.DirEntry) bool { return e.IsDir() && e.Name() != ".dawn" })
-	for d := range dirs {
-		pkg, _ := label.Join(path, d.Name())
-		if err := proj.loadPackage(ctx, wg, pkg); err != nil {
-			return err
-		}
-	}
-
-	return nil
+// loadPackage loads the package at the given path and all of its subpackages in parallel.
+func (proj *Project) loadPackage(ctx context.Context, path string) error {
+	return proj.forEachPackage(path, func(pkg string) {
+		_, _ = proj.loadModule(ctx, nil, &label.Label{Kind: "module", Package: pkg, Name: "BUILD.dawn"})
+	})
 }
 
 func (proj *Project) loadModule(ctx context.Context, waiter *module, label *label.Label) (starlark.StringDict, error) {
@@ -549,7 +799,23 @@ func (proj *Project) loadModule(ctx context.Context, waiter *module, label *labe
 	return m.load(ctx, proj)
 }
 
-func (proj *Project) loadFunction(m *module, l *label.Label, dependencies, sources, generates []string, fn starlark.Callable, always bool, docs string, pos *syntax.Position) (*function, error) {
+// functionOptions holds the optional attributes of a function target.
+type functionOptions struct {
+	env       []string         // the environment variables that the function depends on
+	tools     []string         // the names of the tools that the function depends on
+	depfile   string           // the absolute path of the function's depfile, if any
+	always    bool             // true if the function is always run
+	service   bool             // true if the function is a long-running service
+	sandbox   bool             // true if the function's processes are sandboxed
+	docs      string           // the function's documentation. defaults to the function's docstring.
+	resources map[string]int   // the amounts of each resource pool that the function requires
+	timeout   time.Duration    // the function's timeout, or zero if the function has no timeout
+	retries   int              // the number of times that a failed run of the function is retried
+	pos       *syntax.Position // the position of the function's definition. defaults to the function's position.
+}
+
+func (proj *Project) loadFunction(m *module, l *label.Label, dependencies, sources, generates []string, fn starlark.Callable, options functionOptions) (*function, error) {
+	docs, pos := options.docs, options.pos
 	if docs == "" {
 		if hasdoc, ok := fn.(starlark.HasDoc); ok {
 			docs = hasdoc.Doc()
@@ -569,23 +835,37 @@ func (proj *Project) loadFunction(m *module, l *label.Label, dependencies, sourc
 		return nil, fmt.Errorf("duplicate target %v", rawlabel)
 	}
 	f := &function{
-		proj:     proj,
-		module:   m,
-		label:    l.Copy(),
-		deps:     dependencies,
-		sources:  sources,
-		gens:     generates,
-		docs:     docs,
-		pos:      pos,
-		function: fn,
-		always:   always,
-		out:      newLineWriter(l, proj.events),
+		proj:      proj,
+		module:    m,
+		label:     l.Copy(),
+		deps:      dependencies,
+		sources:   sources,
+		gens:      generates,
+		env:       options.env,
+		tools:     options.tools,
+		depfile:   options.depfile,
+		docs:      docs,
+		pos:       pos,
+		function:  fn,
+		always:    options.always,
+		service:   options.service,
+		sandbox:   options.sandbox,
+		resources: options.resources,
+		timeout:   options.timeout,
+		retries:   options.retries,
+		out:       newLineWriter(l, proj.events),
 	}
 	proj.targets[rawlabel] = &runTarget{target: f}
+	loaded := proj.config != ""
 	proj.m.Unlock()
 
-	if err := f.load(); err != nil {
-		return nil, err
+	// If the project has already been loaded (e.g. the target is being defined by the REPL),
+	// load the target's information immediately. Otherwise, target information is loaded once
+	// all flags have been parsed.
+	if loaded {
+		if err := f.load(); err != nil {
+			return nil, err
+		}
 	}
 	return f, nil
 }
@@ -607,16 +887,23 @@ func (proj *Project) loadSourceFile(l *label.Label) (*sourceFile, error) {
 		path:  path,
 	}
 	proj.targets[rawlabel] = &runTarget{target: f}
+	loaded := proj.config != ""
 	proj.m.Unlock()
 
-	if err := f.load(); err != nil {
-		return nil, err
+	// As with functions, only load the source's information if the project has been loaded.
+	if loaded {
+		if err := f.load(); err != nil {
+			return nil, err
+		}
 	}
 	return f, nil
 }
 
 // link adds dependencies between targets that generate source files and the source files themselves.
 func (proj *Project) link() error {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
 	for _, t := range proj.targets {
 		for _, g := range t.target.generates() {
 			g = g[len(proj.root)+1:]
@@ -629,11 +916,15 @@ func (proj *Project) link() error {
 			if !ok {
 				continue
 			}
+			// Lazy projects are linked each time a package is loaded, so a source file may
+			// already be linked to its generator.
 			generated := f.target.(*sourceFile)
-			if generated.generator != nil {
+			switch {
+			case generated.generator == nil:
+				generated.generator = t.target.Label()
+			case *generated.generator != *t.target.Label():
 				return fmt.Errorf("multiple generators for %v: %v, %v", label, t.target.Label(), generated.generator)
 			}
-			generated.generator = t.target.Label()
 		}
 	}
 	return nil
@@ -734,6 +1025,25 @@ func (r resolveEvents) ProjectLoadFailed(req project.RequirementConfig, err erro
 //	            A list of the files generated by the target as absolute host paths.
 //	            """
 //
+//	        @attribute
+//	        def resources():
+//	            """
+//	            A dict that maps the names of resource pools to the amounts the
+//	            target requires from each pool.
+//	            """
+//
+//	        @attribute
+//	        def service():
+//	            """
+//	            True if the target is a long-running service.
+//	            """
+//
+//	        @attribute
+//	        def sandbox():
+//	            """
+//	            True if the processes run by the target are sandboxed.
+//	            """
+//
 //	    @function("*Project.builtin_path")
 //	    def path():
 //	        pass
@@ -762,6 +1072,10 @@ func (r resolveEvents) ProjectLoadFailed(req project.RequirementConfig, err erro
 //	    def fail():
 //	        pass
 //
+//	    @function("*Project.builtin_require")
+//	    def require():
+//	        pass
+//
 //	    # REPL methods
 //
 //	    @function("*Project.builtin_get_target")
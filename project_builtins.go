@@ -12,8 +12,11 @@ import (
 	"slices"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/pgavlin/dawn/internal/sandbox"
 	"github.com/pgavlin/dawn/label"
+	"github.com/pgavlin/dawn/runner"
 	"github.com/pgavlin/dawn/util"
 	"github.com/pgavlin/fx/v2"
 	fxs "github.com/pgavlin/fx/v2/slices"
@@ -38,6 +41,7 @@ type flagValue struct {
 	thread *starlark.Thread
 	type_  starlark.Callable
 	set    bool
+	raw    string
 }
 
 func (a *flagValue) String() string {
@@ -52,7 +56,7 @@ func (a *flagValue) Set(s string) error {
 	if err != nil {
 		return err
 	}
-	a.v, a.set = v, true
+	a.v, a.set, a.raw = v, true, s
 	return nil
 }
 
@@ -60,6 +64,36 @@ func (a *flagValue) Type() string {
 	return a.type_.Name()
 }
 
+// rawFlagValue implements pflag.Value by recording a flag's raw argument.
+type rawFlagValue struct {
+	flagType string
+	raw      *string
+}
+
+func (a *rawFlagValue) String() string {
+	return ""
+}
+
+func (a *rawFlagValue) Set(s string) error {
+	a.raw = &s
+	return nil
+}
+
+func (a *rawFlagValue) Type() string {
+	return a.flagType
+}
+
+// parseFlagArgs parses the argument for the flag with the given name and type from args into
+// value.
+func parseFlagArgs(name, flagType, help string, value pflag.Value, args []string) error {
+	set := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	set.Var(value, name, help)
+	if flagType == "bool" {
+		set.Lookup(name).NoOptDefVal = "False"
+	}
+	return set.Parse(args)
+}
+
 // starlark
 //
 //	def path(label):
@@ -222,15 +256,14 @@ func (proj *Project) builtin_parse_flag(
 	proj.m.Unlock()
 
 	flagValue := flagValue{v: default_, thread: thread, type_: type_}
-	set := pflag.NewFlagSet(name, pflag.ContinueOnError)
-	set.Var(&flagValue, name, help)
-	if type_.Name() == "bool" {
-		set.Lookup(name).NoOptDefVal = "False"
-	}
-
-	if err := set.Parse(proj.args); err != nil {
+	if err := parseFlagArgs(name, type_.Name(), help, &flagValue, proj.args); err != nil {
 		return nil, fmt.Errorf("%v: %w", fn.Name(), err)
 	}
+	if flagValue.set {
+		m.recordFlag(name, &flagValue.raw)
+	} else {
+		m.recordFlag(name, nil)
+	}
 
 	if required && !flagValue.set {
 		return nil, fmt.Errorf("%v: missing required flag --%s", fn.Name(), name)
@@ -259,7 +292,7 @@ func (proj *Project) builtin_parse_flag(
 func (proj *Project) builtin_targetDecorator(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	if len(args) == 1 {
 		if function, decorator := args[0].(*starlark.Function); decorator {
-			return proj.builtin_target(thread, fn, function.Name(), starlark.Tuple{}, nil, nil, function, false, false, "")
+			return proj.builtin_target(thread, fn, function.Name(), starlark.Tuple{}, nil, nil, function, false, false, "", nil, "", 0, false, false, nil, nil, "")
 		}
 	}
 
@@ -268,7 +301,7 @@ func (proj *Project) builtin_targetDecorator(thread *starlark.Thread, fn *starla
 
 // starlark
 //
-//	def target(name=None, deps=None, sources=None, generates=None, function=None, default=None, always=None, docs=None):
+//	def target(name=None, deps=None, sources=None, generates=None, function=None, default=None, always=None, docs=None, resources=None, timeout=None, retries=None, service=None, sandbox=None, env=None, tools=None, depfile=None):
 //	    """
 //	    Defines a new build target in the current package. Typically used as a
 //	    decorator, in which case the decorated function is treated as the value
@@ -290,6 +323,38 @@ func (proj *Project) builtin_targetDecorator(thread *starlark.Thread, fn *starla
 //	    :param always: True if the target should always be considered out-of-date.
 //	    :param docs: the docs for the target. Normally picked up from the
 //	                 function's docstring.
+//	    :param resources: the resources the target requires while it runs. Must be
+//	                      a mapping from resource pool names to amounts. Unless
+//	                      specified, a target requires one unit of the cpu pool.
+//	    :param timeout: the maximum duration of each attempt to run the target,
+//	                    e.g. "10m". When the timeout elapses, the attempt is
+//	                    cancelled and any processes it started are
+//	                    interrupted, then killed if they have not exited after
+//	                    five seconds.
+//	    :param retries: the number of times to retry the target if it fails.
+//	    :param service: True if the target is a long-running service. A service's
+//	                    function runs in the background, and the service is
+//	                    restarted each time it is re-run. Services may not
+//	                    generate files or specify depfiles, timeouts, or
+//	                    retries.
+//	    :param sandbox: True if the processes the target runs using os.exec and
+//	                    sh.exec should be sandboxed. Sandboxed processes may
+//	                    only read the target's sources and the files generated
+//	                    by its dependencies, and may only write the files the
+//	                    target generates. Sandboxing is only supported on Linux.
+//	    :param env: the names of the environment variables that the target
+//	                depends on. The target is re-run if the value of any of
+//	                these variables changes.
+//	    :param tools: the names of the executables that the target depends on.
+//	                  Each name is resolved using the PATH environment
+//	                  variable (see os.look_path), and the target is re-run if
+//	                  the resolved executable or its contents change.
+//	    :param depfile: a Makefile-style dependency file written by the target,
+//	                    e.g. by a C compiler's -MD option. The path is
+//	                    interpreted identically to those in the sources
+//	                    parameter. After the target runs, the prerequisites
+//	                    listed in the file are treated as additional sources,
+//	                    and the target is re-run if any of them change.
 //
 //	    :returns: the new build target object or a decorator if function is None.
 //	    """
@@ -306,6 +371,14 @@ func (proj *Project) builtin_target(
 	default_ bool,
 	always bool,
 	docs string,
+	resources starlark.IterableMapping,
+	timeout string,
+	retries int,
+	service bool,
+	sandbox bool,
+	env util.StringList,
+	tools util.StringList,
+	depfile string,
 ) (starlark.Value, error) {
 	// If the function is nil, treat this as a decorator. Otherwise, create a new target.
 	if function == nil {
@@ -314,7 +387,7 @@ func (proj *Project) builtin_target(
 			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &function); err != nil {
 				return nil, err
 			}
-			return proj.builtin_target(thread, fn, name, deps, sources, generates, function, default_, always, docs)
+			return proj.builtin_target(thread, fn, name, deps, sources, generates, function, default_, always, docs, resources, timeout, retries, service, sandbox, env, tools, depfile)
 		}), nil
 	}
 
@@ -334,24 +407,7 @@ func (proj *Project) builtin_target(
 	var dependencies []string //nolint:prealloc
 	if deps != nil {
 		deps, err := fxs.TryCollect(fx.MapUnpack(util.All(deps), func(dep starlark.Value) (string, error) {
-			var deplabel *label.Label
-			switch dep := dep.(type) {
-			case starlark.String:
-				l, err := label.Parse(string(dep))
-				if err != nil {
-					return "", err
-				}
-				l, err = l.RelativeTo(m.label.Package)
-				if err != nil {
-					return "", err
-				}
-				deplabel = l
-			case Target:
-				deplabel = dep.Label()
-			default:
-				return "", fmt.Errorf("%v: dependency is a %s, not a string or target", fn.Name(), dep.Type())
-			}
-			return deplabel.String(), nil
+			return dependencyLabel(fn, m.label.Package, dep)
 		}))
 		if err != nil {
 			return nil, err
@@ -397,6 +453,64 @@ func (proj *Project) builtin_target(
 		sourcePaths, dependencies = append(sourcePaths, f.path), append(dependencies, label.String())
 	}
 
+	// Process resources.
+	var required map[string]int
+	if resources != nil {
+		required = map[string]int{}
+		for _, kvp := range resources.Items() {
+			pool, ok := starlark.AsString(kvp[0])
+			if !ok {
+				return nil, fmt.Errorf("%v: resource pool name is a %s, not a string", fn.Name(), kvp[0].Type())
+			}
+			if _, ok := proj.resources[pool]; !ok && pool != runner.DefaultPool {
+				return nil, fmt.Errorf("%v: unknown resource pool %q", fn.Name(), pool)
+			}
+			var amount int
+			if err := starlark.AsInt(kvp[1], &amount); err != nil || amount < 0 {
+				return nil, fmt.Errorf("%v: the amount of resource %q must be a non-negative integer", fn.Name(), pool)
+			}
+			required[pool] = amount
+		}
+	}
+
+	// Process the timeout and retries.
+	var timeoutDuration time.Duration
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%v: invalid timeout %q: must be a positive duration", fn.Name(), timeout)
+		}
+		timeoutDuration = d
+	}
+	if retries < 0 {
+		return nil, fmt.Errorf("%v: retries must be non-negative", fn.Name())
+	}
+	if service && (len(gens) != 0 || depfile != "" || timeoutDuration != 0 || retries != 0) {
+		return nil, fmt.Errorf("%v: services may not generate files or specify depfiles, timeouts, or retries", fn.Name())
+	}
+
+	// Process the depfile.
+	var depfilePath string
+	if depfile != "" {
+		path, err := repoSourcePath(m.label.Package, depfile)
+		if err != nil {
+			return nil, err
+		}
+		depfilePath = filepath.Join(proj.root, filepath.Join(strings.Split(path, "/")...))
+	}
+
+	// Process the environment variables and tools.
+	for _, name := range env {
+		if name == "" || strings.Contains(name, "=") {
+			return nil, fmt.Errorf("%v: invalid environment variable name %q", fn.Name(), name)
+		}
+	}
+	for _, name := range tools {
+		if name == "" {
+			return nil, fmt.Errorf("%v: tool names must not be empty", fn.Name())
+		}
+	}
+
 	// TODO: allow annotations for helper functions, then skip those frames as well?
 	var pos *syntax.Position
 	for i, depth := 1, thread.CallStackDepth(); i < depth; i++ {
@@ -407,7 +521,19 @@ func (proj *Project) builtin_target(
 		}
 	}
 
-	f, err := proj.loadFunction(m, l, dependencies, sourcePaths, gens, function, always, docs, pos)
+	f, err := proj.loadFunction(m, l, dependencies, sourcePaths, gens, function, functionOptions{
+		env:       env,
+		tools:     tools,
+		depfile:   depfilePath,
+		always:    always,
+		service:   service,
+		sandbox:   sandbox,
+		docs:      docs,
+		resources: required,
+		timeout:   timeoutDuration,
+		retries:   retries,
+		pos:       pos,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("%v: %w", fn.Name(), err)
 	}
@@ -417,7 +543,7 @@ func (proj *Project) builtin_target(
 			Package: m.label.Package,
 			Name:    "default",
 		}
-		if _, err = proj.loadFunction(m, defaultLabel, []string{l.String()}, nil, nil, builtin_default(function.Doc()), false, "", pos); err != nil {
+		if _, err = proj.loadFunction(m, defaultLabel, []string{l.String()}, nil, nil, builtin_default(function.Doc()), functionOptions{pos: pos}); err != nil {
 			return nil, err
 		}
 	}
@@ -425,6 +551,90 @@ func (proj *Project) builtin_target(
 	return f, nil
 }
 
+// dependencyLabel returns the label of the given dependency, which must be either a target or a
+// label relative to the given package.
+func dependencyLabel(fn *starlark.Builtin, pkg string, dep starlark.Value) (string, error) {
+	switch dep := dep.(type) {
+	case starlark.String:
+		l, err := label.Parse(string(dep))
+		if err != nil {
+			return "", err
+		}
+		l, err = l.RelativeTo(pkg)
+		if err != nil {
+			return "", err
+		}
+		return l.String(), nil
+	case Target:
+		return dep.Label().String(), nil
+	default:
+		return "", fmt.Errorf("%v: dependency is a %s, not a string or target", fn.Name(), dep.Type())
+	}
+}
+
+// starlark
+//
+//	def require(deps):
+//	    """
+//	    Builds additional dependencies of the calling target while the target
+//	    runs. Intended for targets that only learn what they depend on after
+//	    inspecting their inputs. The calling target waits for the required
+//	    targets to be built, and the required targets are treated as
+//	    dependencies of the calling target the next time it is checked for
+//	    changes. May only be called by a running target.
+//
+//	    :param deps: the targets to build. Must be a label or target, or a
+//	                 sequence of labels or targets.
+//
+//	    :returns: the required target, or a list of the required targets if
+//	              deps is a sequence.
+//	    """
+//
+//starlark:builtin
+func (proj *Project) builtin_require(thread *starlark.Thread, fn *starlark.Builtin, deps starlark.Value) (starlark.Value, error) {
+	reqs, ok := thread.Local("requirements").(*requirements)
+	if !ok {
+		return nil, fmt.Errorf("%v: may only be called by a running target", fn.Name())
+	}
+	m := thread.Local("module").(*module)
+
+	var values []starlark.Value
+	switch deps := deps.(type) {
+	case starlark.String, Target:
+		values = []starlark.Value{deps}
+	case starlark.Iterable:
+		values = slices.Collect(util.All(deps))
+	default:
+		return nil, fmt.Errorf("%v: deps must be a label, a target, or a sequence", fn.Name())
+	}
+
+	labels, err := fxs.TryCollect(fx.MapUnpack(slices.Values(values), func(dep starlark.Value) (string, error) {
+		return dependencyLabel(fn, m.label.Package, dep)
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := reqs.require(util.GetContext(thread), labels)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", fn.Name(), err)
+	}
+
+	// Make the files generated by the required targets visible to sandboxed processes.
+	if s := sandbox.ThreadSandbox(thread); s != nil {
+		s.Inputs = append(s.Inputs, proj.dependencyInputs(labels)...)
+	}
+
+	if _, ok := deps.(starlark.Iterable); !ok {
+		return targets[0], nil
+	}
+	list := make([]starlark.Value, len(targets))
+	for i, t := range targets {
+		list[i] = t
+	}
+	return starlark.NewList(list), nil
+}
+
 // starlark
 //
 //	def glob(include, exclude=None, dirs=None):
@@ -474,23 +684,35 @@ func (proj *Project) builtin_glob(
 	dirs bool,
 ) (starlark.Value, error) {
 	m := thread.Local("module").(*module)
-	dir := filepath.Dir(m.path)
 
-	exclude = append(exclude, ".dawn/build")
+	paths, err := globPaths(filepath.Dir(m.path), include, exclude, dirs)
+	if err != nil {
+		return nil, err
+	}
+	m.recordGlob(globInput{Include: include, Exclude: exclude, Dirs: dirs, Sum: globSum(paths)})
 
-	g, err := glob.New([]string(include), []string(exclude))
+	matches := make([]starlark.Value, len(paths))
+	for i, p := range paths {
+		matches[i] = starlark.String(p)
+	}
+	return starlark.NewList(matches), nil
+}
+
+// globPaths returns the paths beneath dir that match the given patterns.
+func globPaths(dir string, include, exclude []string, dirs bool) ([]string, error) {
+	g, err := glob.New(include, append(slices.Clip(exclude), ".dawn/build"))
 	if err != nil {
 		return nil, err
 	}
 
-	var matches []starlark.Value //nolint:prealloc
+	var paths []string
 	for p, err := range g.Match(os.DirFS(dir), ".", dirs) {
 		if err != nil {
 			return nil, err
 		}
-		matches = append(matches, starlark.String(p))
+		paths = append(paths, p)
 	}
-	return starlark.NewList(matches), nil
+	return paths, nil
 }
 
 // starlark
@@ -499,7 +721,7 @@ func (proj *Project) builtin_glob(
 //	    """
 //	    Builds a target.
 //
-//	    :param label_or_target: the label or target to run.
+//	    :param label_or_target: the label or target to run. The label may be a pattern.
 //	    :param always: True if all targets should be considered out-of-date.
 //	    :param dry_run: True if the targets to run should be displayed but not run.
 //	    :param callback: a callback that receives build events. If absent,
@@ -520,7 +742,7 @@ func (proj *Project) builtin_run(
 	var l *label.Label
 	switch labelOrTarget := labelOrTarget.(type) {
 	case starlark.String:
-		l, err = label.Parse(string(labelOrTarget))
+		l, err = label.ParsePattern(string(labelOrTarget))
 		if err != nil {
 			return nil, err
 		}
@@ -561,7 +783,7 @@ func (proj *Project) builtin_run(
 			Always: always,
 			DryRun: dryRun,
 		}
-		return proj.Run(util.GetContext(thread), l, &options)
+		return proj.Run(util.GetContext(thread), []*label.Label{l}, &options)
 	}()
 	return starlark.None, errors.Join(err, <-eventsErr)
 }
@@ -1,6 +1,7 @@
 package dawn
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -28,6 +29,13 @@ func (proj *Project) loadConfigFile(p string) error {
 		proj.ignore = ignore
 	}
 
+	// Remote cache settings from load options take precedence over those in the config file.
+	proj.cache.Remote = cmp.Or(proj.cache.Remote, c.Cache.Remote)
+	proj.cache.Mode = cmp.Or(proj.cache.Mode, c.Cache.Mode)
+
+	proj.resources = c.Resources
+	proj.sandbox = c.Sandbox
+
 	reqs := make(map[string]string)
 	for name, req := range c.Requirements {
 		reqs[name] = req.Path
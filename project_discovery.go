@@ -0,0 +1,165 @@
+package dawn
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/pgavlin/dawn/label"
+	"github.com/pgavlin/dawn/runner"
+)
+
+// defaultSkips holds the names of directories that are never searched for packages.
+var defaultSkips = []string{".dawn", ".git", "node_modules"}
+
+// discoverPackages returns the paths of the package at the given path and each of its
+// subpackages. Directories that are ignored by the project's configuration or by .gitignore
+// files are skipped, as are directories named in defaultSkips. Once discovery finishes, a
+// PackagesDiscovered event is emitted.
+func (proj *Project) discoverPackages(path string) ([]string, error) {
+	start := time.Now()
+
+	var packages []string
+	dirs, err := proj.walkPackages(path, proj.gitignores(path), func(pkg string) {
+		packages = append(packages, pkg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	proj.events.PackagesDiscovered(len(packages), dirs, time.Since(start))
+	return packages, nil
+}
+
+// gitignores returns the patterns from the .gitignore files in the ancestors of the package at
+// the given path.
+func (proj *Project) gitignores(path string) []gitignore.Pattern {
+	if path == "//" {
+		return nil
+	}
+
+	var patterns []gitignore.Pattern
+	components := label.Split(path)[1:]
+	for i := range components {
+		domain := components[:i]
+		ps, err := readGitignore(filepath.Join(proj.root, filepath.Join(domain...), ".gitignore"), domain)
+		if err == nil {
+			patterns = append(patterns, ps...)
+		}
+	}
+	return patterns
+}
+
+// walkPackages calls fn for the package at the given path and each of its subpackages and
+// returns the number of directories it visited. patterns holds the .gitignore patterns that
+// apply to the package's directory.
+func (proj *Project) walkPackages(path string, patterns []gitignore.Pattern, fn func(pkg string)) (int, error) {
+	if proj.ignored(path[2:]) {
+		return 0, nil
+	}
+
+	var domain []string
+	if path != "//" {
+		domain = label.Split(path)[1:]
+	}
+
+	dir := filepath.Join(proj.root, filepath.Join(domain...))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	if slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == ".gitignore" }) {
+		ps, err := readGitignore(filepath.Join(dir, ".gitignore"), domain)
+		if err != nil {
+			return 0, err
+		}
+		patterns = append(slices.Clip(patterns), ps...)
+	}
+	if slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == "BUILD.dawn" }) {
+		fn(path)
+	}
+
+	matcher := gitignore.NewMatcher(patterns)
+	dirs := 1
+	for _, e := range entries {
+		if !e.IsDir() || slices.Contains(defaultSkips, e.Name()) || matcher.Match(append(slices.Clip(domain), e.Name()), true) {
+			continue
+		}
+
+		pkg, _ := label.Join(path, e.Name())
+		n, err := proj.walkPackages(pkg, patterns, fn)
+		if err != nil {
+			return 0, err
+		}
+		dirs += n
+	}
+	return dirs, nil
+}
+
+// readGitignore reads the patterns in the .gitignore file at the given path. domain holds the
+// path components of the directory that contains the file.
+func readGitignore(path string, domain []string) ([]gitignore.Pattern, error) {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "" {
+			patterns = append(patterns, gitignore.ParsePattern(line, domain))
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// forEachPackage discovers the package at the given path and its subpackages, then calls fn for
+// each package in parallel.
+func (proj *Project) forEachPackage(path string, fn func(pkg string)) error {
+	packages, err := proj.discoverPackages(path)
+	if err != nil {
+		return err
+	}
+	proj.forEach(packages, fn)
+	return nil
+}
+
+// forEach calls fn for each of the given items in parallel. At most loadConcurrency calls to fn
+// run at once.
+func (proj *Project) forEach(items []string, fn func(item string)) {
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range min(proj.loadConcurrency(), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+}
+
+// loadConcurrency returns the maximum number of packages that are loaded in parallel, which is
+// the capacity of the project's cpu resource pool.
+func (proj *Project) loadConcurrency() int {
+	if n, ok := proj.resources[runner.DefaultPool]; ok {
+		return n
+	}
+	return runtime.NumCPU()
+}
@@ -0,0 +1,163 @@
+package dawn
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/pgavlin/dawn/internal/sandbox"
+	"github.com/pgavlin/dawn/label"
+)
+
+// A HermeticityReport describes the undeclared files accessed by the processes run by a target.
+type HermeticityReport struct {
+	// Target is the target that accessed undeclared files.
+	Target Target
+	// Reads holds the project-relative paths of the files that were read by the target but are
+	// neither sources of the target nor generated by its dependencies.
+	Reads []string
+	// Writes holds the project-relative paths of the files that were written by the target but
+	// are not generated by the target.
+	Writes []string
+}
+
+// CheckHermeticity builds the given targets and their dependencies and reports the undeclared
+// files that were accessed by each target. Every target is re-run, and the processes each target
+// starts using os.exec or sh.exec run in an auditing sandbox: a temporary copy of the project
+// that contains the contents of the target's declared inputs and empty placeholders for every
+// other file. Files that are ignored by the project's configuration or by .gitignore files and
+// the contents of node_modules directories are left out of the copy. Once each process exits,
+// the placeholders it read and the files it wrote are compared with the target's declarations.
+//
+// Checking hermeticity does not modify the project: the outputs of audited processes are written
+// to a scratch directory that is removed once the check completes, the targets' results are not
+// recorded in the action cache, and each target is re-run by the next build.
+//
+// The returned reports are sorted by label and only describe targets that accessed undeclared
+// files. The reports are returned even if the build fails.
+func (proj *Project) CheckHermeticity(ctx context.Context, labels []*label.Label, options *RunOptions) (_ []*HermeticityReport, err error) {
+	if err := os.MkdirAll(proj.temp, 0o750); err != nil {
+		return nil, err
+	}
+	scratch, err := os.MkdirTemp(proj.temp, "audit-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, os.RemoveAll(scratch))
+	}()
+
+	skip, err := proj.auditSkip()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every target must be re-run, so the runner must neither use the results of earlier runs nor
+	// retain the results of audited runs.
+	proj.m.Lock()
+	proj.audits, proj.scratch, proj.skip = map[string]*sandbox.Audit{}, scratch, skip
+	proj.runner.Invalidate(slices.Collect(maps.Keys(proj.targets))...)
+	proj.m.Unlock()
+
+	var opts RunOptions
+	if options != nil {
+		opts = *options
+	}
+	opts.Always, opts.DryRun = true, false
+	err = proj.Run(ctx, labels, &opts)
+
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	audits := proj.audits
+	proj.audits, proj.scratch, proj.skip = nil, "", nil
+	proj.runner.Invalidate(slices.Collect(maps.Keys(proj.targets))...)
+
+	var reports []*HermeticityReport
+	for _, l := range slices.Sorted(maps.Keys(audits)) {
+		reads, writes := audits[l].Reads(), audits[l].Writes()
+		t, ok := proj.targets[l]
+		if !ok || len(reads) == 0 && len(writes) == 0 {
+			continue
+		}
+		reports = append(reports, &HermeticityReport{Target: t.target, Reads: reads, Writes: writes})
+	}
+	return reports, err
+}
+
+// auditSkip returns a function that returns true for the files and directories that are left out
+// of the staging directories of audited processes: those ignored by the project's configuration
+// or by .gitignore files and the directories named in defaultSkips.
+func (proj *Project) auditSkip() (func(rel string, isDir bool) bool, error) {
+	var patterns []gitignore.Pattern
+	skip := func(rel string, isDir bool) bool {
+		if proj.ignored(rel) {
+			return true
+		}
+		components := strings.Split(rel, "/")
+		if isDir && slices.Contains(defaultSkips, components[len(components)-1]) {
+			return true
+		}
+		return gitignore.NewMatcher(patterns).Match(components, isDir)
+	}
+
+	err := filepath.WalkDir(proj.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(proj.root, path)
+		if err != nil {
+			return err
+		}
+		var domain []string
+		if rel != "." {
+			rel = filepath.ToSlash(rel)
+			if skip(rel, true) {
+				return fs.SkipDir
+			}
+			domain = strings.Split(rel, "/")
+		}
+
+		ps, err := readGitignore(filepath.Join(path, ".gitignore"), domain)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		patterns = append(patterns, ps...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return skip, nil
+}
+
+// audit returns the audit for the target with the given label if the project is checking
+// hermeticity and nil otherwise.
+func (proj *Project) audit(label string) *sandbox.Audit {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	if proj.audits == nil {
+		return nil
+	}
+	a, ok := proj.audits[label]
+	if !ok {
+		a = sandbox.NewAudit(proj.scratch)
+		proj.audits[label] = a
+	}
+	return a
+}
+
+// auditing returns true if the project is checking hermeticity.
+func (proj *Project) auditing() bool {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	return proj.audits != nil
+}
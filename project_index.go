@@ -2,13 +2,19 @@ package dawn
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 
 	"github.com/pgavlin/dawn/diff"
 	"github.com/pgavlin/dawn/label"
+	"github.com/pgavlin/dawn/runner"
 	"github.com/pgavlin/starlark-go/starlark"
 	"github.com/sugawarayuuta/sonnet"
 )
@@ -24,8 +30,53 @@ type TargetSummary struct {
 }
 
 type index struct {
-	Flags   []*Flag         `json:"flags,omitempty"`
-	Targets []TargetSummary `json:"targets,omitempty"`
+	Config   string          `json:"config,omitempty"`
+	Flags    []*Flag         `json:"flags,omitempty"`
+	Targets  []TargetSummary `json:"targets,omitempty"`
+	Packages []string        `json:"packages,omitempty"`
+	Modules  []moduleInputs  `json:"modules,omitempty"`
+}
+
+// moduleInputs records the inputs that determined the result of loading a module. An index is
+// only reused if the inputs of each of its modules are unchanged.
+type moduleInputs struct {
+	// Label is the module's label.
+	Label string `json:"label"`
+	// Path is the path to the module's source.
+	Path string `json:"path"`
+	// Sum is the SHA-256 sum of the module's source.
+	Sum string `json:"sum"`
+	// Loads maps the label of each module loaded by the module to that module's sum.
+	Loads map[string]string `json:"loads,omitempty"`
+	// Globs records the results of the module's calls to glob.
+	Globs []globInput `json:"globs,omitempty"`
+	// Flags maps the name of each flag parsed by the module to the flag's raw argument, or to
+	// nil if the flag was not set.
+	Flags map[string]*string `json:"flags,omitempty"`
+}
+
+// globInput records the results of a call to glob.
+type globInput struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	Dirs    bool     `json:"dirs,omitempty"`
+	// Sum is the SHA-256 sum of the matched paths.
+	Sum string `json:"sum"`
+}
+
+// moduleSum returns the SHA-256 sum of a module's source.
+func moduleSum(src []byte) string {
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
+
+// globSum returns the SHA-256 sum of the paths matched by a call to glob.
+func globSum(paths []string) string {
+	h := sha256.New()
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s\x00", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // Targets returns the targets listed in the index file of the project rooted at the given
@@ -105,11 +156,15 @@ func (t *indexTarget) info() targetInfo {
 	}
 }
 
+func (*indexTarget) load() error {
+	return nil
+}
+
 func (t *indexTarget) upToDate(_ context.Context) (bool, string, diff.ValueDiff, error) {
 	return true, "", nil, nil
 }
 
-func (*indexTarget) evaluate(_ context.Context) (data string, changed bool, err error) {
+func (*indexTarget) evaluate(_ context.Context, _ runner.Engine) (data string, changed bool, err error) {
 	return "", false, errors.New("index targets are not executable; please reload the project")
 }
 
@@ -124,7 +179,11 @@ func (proj *Project) loadIndex() error {
 	if err := sonnet.NewDecoder(f).Decode(&index); err != nil {
 		return err
 	}
+	if err := proj.checkIndex(&index); err != nil {
+		return err
+	}
 
+	proj.config = index.Config
 	for _, flag := range index.Flags {
 		proj.flags[flag.Name] = flag
 	}
@@ -176,6 +235,74 @@ func (proj *Project) loadIndex() error {
 	return nil
 }
 
+// checkIndex returns an error if the project's packages or the inputs of any of the modules
+// recorded in the given index have changed.
+func (proj *Project) checkIndex(index *index) error {
+	if len(index.Modules) == 0 {
+		return errors.New("index does not record module inputs")
+	}
+
+	packages, err := proj.discoverPackages("//")
+	if err != nil {
+		return err
+	}
+	slices.Sort(packages)
+	if !slices.Equal(packages, index.Packages) {
+		return errors.New("packages changed")
+	}
+
+	sums := make(map[string]string, len(index.Modules))
+	for _, m := range index.Modules {
+		sums[m.Label] = m.Sum
+	}
+	flags := make(map[string]*Flag, len(index.Flags))
+	for _, f := range index.Flags {
+		flags[f.Name] = f
+	}
+
+	for _, m := range index.Modules {
+		//nolint:gosec
+		src, err := os.ReadFile(m.Path)
+		if err != nil {
+			return err
+		}
+		if moduleSum(src) != m.Sum {
+			return fmt.Errorf("module %v changed", m.Label)
+		}
+
+		for dep, sum := range m.Loads {
+			if sums[dep] != sum {
+				return fmt.Errorf("module %v changed", dep)
+			}
+		}
+
+		for _, g := range m.Globs {
+			paths, err := globPaths(filepath.Dir(m.Path), g.Include, g.Exclude, g.Dirs)
+			if err != nil {
+				return err
+			}
+			if globSum(paths) != g.Sum {
+				return fmt.Errorf("glob results for module %v changed", m.Label)
+			}
+		}
+
+		for name, raw := range m.Flags {
+			f, ok := flags[name]
+			if !ok {
+				return fmt.Errorf("unknown flag %v", name)
+			}
+			value := rawFlagValue{flagType: f.FlagType}
+			if err := parseFlagArgs(name, f.FlagType, f.Help, &value, proj.args); err != nil {
+				return err
+			}
+			if (raw == nil) != (value.raw == nil) || raw != nil && *raw != *value.raw {
+				return fmt.Errorf("flag %v changed", name)
+			}
+		}
+	}
+	return nil
+}
+
 func (proj *Project) saveIndex() error {
 	f, err := os.Create(filepath.Join(proj.work, "index.json"))
 	if err != nil {
@@ -184,6 +311,7 @@ func (proj *Project) saveIndex() error {
 	defer f.Close()
 
 	index := index{
+		Config:  proj.config,
 		Flags:   make([]*Flag, 0, len(proj.args)),
 		Targets: make([]TargetSummary, 0, len(proj.targets)),
 	}
@@ -200,6 +328,32 @@ func (proj *Project) saveIndex() error {
 	}
 	sort.Slice(index.Targets, func(i, j int) bool { return index.Targets[i].Label.String() < index.Targets[j].Label.String() })
 
+	for _, m := range proj.modules {
+		if m.label.Project == "" && m.label.Name == "BUILD.dawn" {
+			index.Packages = append(index.Packages, m.label.Package)
+		}
+	}
+	slices.Sort(index.Packages)
+
+	for _, label := range slices.Sorted(maps.Keys(proj.modules)) {
+		m := proj.modules[label]
+
+		loads := make(map[string]string, len(m.dependencies))
+		for _, dep := range m.dependencies {
+			if d, ok := proj.modules[dep]; ok {
+				loads[dep] = d.sum
+			}
+		}
+		index.Modules = append(index.Modules, moduleInputs{
+			Label: label,
+			Path:  m.path,
+			Sum:   m.sum,
+			Loads: loads,
+			Globs: m.globs,
+			Flags: m.flags,
+		})
+	}
+
 	enc := sonnet.NewEncoder(f)
 	enc.SetIndent("", "    ")
 	return enc.Encode(index)
@@ -0,0 +1,133 @@
+package dawn
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A projectSnapshot records the configuration and target definitions of a loaded project. After
+// the project is reloaded, the snapshot is used to determine which targets were affected by the
+// reload.
+type projectSnapshot struct {
+	config  string
+	targets map[string]string // maps each target's label to a summary of its definition
+}
+
+// snapshot records the project's current configuration and target definitions.
+func (proj *Project) snapshot() *projectSnapshot {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	targets := make(map[string]string, len(proj.targets))
+	for label, t := range proj.targets {
+		targets[label] = targetDefinition(t.target)
+	}
+	return &projectSnapshot{config: proj.config, targets: targets}
+}
+
+// targetDefinition summarizes the parts of a target's definition that are visible to the runner.
+func targetDefinition(t Target) string {
+	return strings.Join(t.dependencies(), "\x00") + "\x01" + strings.Join(t.generates(), "\x00")
+}
+
+// invalidate resets the runner's state for each target that was affected by changes to the given
+// files since the given snapshot was taken, as well as the state of each of the targets' dependents.
+// The remaining targets retain the results of their last evaluation.
+//
+// A target is affected if:
+//
+//   - it was added, removed, or redefined,
+//   - it is a source file that was changed or a directory that contains a changed file,
+//   - it is a function that generates a changed file,
+//   - it is a function whose depfile listed a changed file after its last run,
+//   - it is a function that is defined by a changed module or a module that transitively loads a
+//     changed module, or
+//   - it is a function that is always run, a service that is not running, or a function that
+//     depends on environment variables or tools.
+//
+// If the project's configuration changed, every target is affected.
+func (proj *Project) invalidate(snapshot *projectSnapshot, changed map[string]struct{}) {
+	proj.runner.Invalidate(proj.affectedTargets(snapshot, changed)...)
+}
+
+// affectedTargets returns the labels of the targets that were affected by changes to the given
+// files since the given snapshot was taken.
+func (proj *Project) affectedTargets(snapshot *projectSnapshot, changed map[string]struct{}) []string {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	var affected []string
+	if proj.config != snapshot.config {
+		for label := range snapshot.targets {
+			affected = append(affected, label)
+		}
+		for label := range proj.targets {
+			affected = append(affected, label)
+		}
+		return affected
+	}
+
+	for label := range snapshot.targets {
+		if _, ok := proj.targets[label]; !ok {
+			affected = append(affected, label)
+		}
+	}
+
+	// Find the changed modules and the modules that load them.
+	modules := map[string]bool{}
+	for label, m := range proj.modules {
+		if _, ok := changed[m.path]; ok {
+			modules[label] = true
+		}
+	}
+	proj.addLoaders(modules)
+
+	for label, t := range proj.targets {
+		if def, ok := snapshot.targets[label]; !ok || def != targetDefinition(t.target) {
+			affected = append(affected, label)
+			continue
+		}
+
+		switch t := t.target.(type) {
+		case *sourceFile:
+			if containsChanged(changed, t.path) {
+				affected = append(affected, label)
+			}
+		case *function:
+			if modules[t.module.label.String()] || t.volatile() {
+				affected = append(affected, label)
+				continue
+			}
+			isChanged := func(path string) bool {
+				_, ok := changed[path]
+				return ok
+			}
+			if slices.ContainsFunc(t.gens, isChanged) || slices.ContainsFunc(t.discoveredPaths(), isChanged) {
+				affected = append(affected, label)
+			}
+		}
+	}
+	return affected
+}
+
+// volatile returns true if the function may be out-of-date regardless of which files changed: if it
+// is always run, if it is a service that is not running, or if it depends on external inputs,
+// which may change without notice (e.g. a tool outside of the project root may be replaced).
+func (f *function) volatile() bool {
+	return f.always || f.service && !f.proj.services.running(f.label.String()) || len(f.env) != 0 || len(f.tools) != 0
+}
+
+// containsChanged returns true if the given path or any file beneath it is a changed file.
+func containsChanged(changed map[string]struct{}, path string) bool {
+	if _, ok := changed[path]; ok {
+		return true
+	}
+	prefix := path + string(filepath.Separator)
+	for p := range changed {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package dawn
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pgavlin/dawn/label"
+	"github.com/sugawarayuuta/sonnet"
+)
+
+// A lazyPackage records the state of a package that is loaded on demand.
+type lazyPackage struct {
+	once sync.Once
+	err  error
+}
+
+// loadLazy performs the initial load of a lazy project. Only the root package and the packages
+// that may define the flags present in the project's arguments are loaded up front. The
+// remaining packages are loaded as their targets are required.
+func (proj *Project) loadLazy(ctx context.Context) error {
+	pkgs := []string{"//"}
+	for _, arg := range proj.args {
+		name, ok := strings.CutPrefix(arg, "--")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, "=")
+
+		// A flag's name is the path of its package joined with its name by dots. Flag names
+		// may themselves contain dots, so any prefix of the name may name its package.
+		components := strings.Split(name, ".")
+		for i := 1; i < len(components); i++ {
+			pkgs = append(pkgs, "//"+strings.Join(components[:i], "/"))
+		}
+	}
+
+	for _, pkg := range pkgs {
+		if err := proj.requirePackage(ctx, pkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requirePackage loads the package with the given path if the project is lazy and the package
+// has not yet been loaded. Once the package is loaded, its targets are linked to the source
+// files they generate.
+func (proj *Project) requirePackage(ctx context.Context, pkg string) error {
+	if !proj.lazy {
+		return nil
+	}
+
+	proj.m.Lock()
+	p, ok := proj.packages[pkg]
+	if !ok {
+		p = &lazyPackage{}
+		proj.packages[pkg] = p
+	}
+	proj.m.Unlock()
+
+	p.once.Do(func() {
+		if proj.ignored(pkg[2:]) {
+			return
+		}
+		if _, err := os.Stat(filepath.Join(proj.root, pkg[2:], "BUILD.dawn")); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				p.err = err
+			}
+			return
+		}
+		if _, err := proj.loadModule(ctx, nil, &label.Label{Kind: "module", Package: pkg, Name: "BUILD.dawn"}); err != nil {
+			p.err = err
+			return
+		}
+		p.err = proj.link()
+	})
+	return p.err
+}
+
+// requireLabel loads the package that defines the target with the given label. In order to find
+// the generator of a source file, the nearest package that contains the file is loaded, followed
+// by the package of the generator recorded for the file by earlier runs, if any. If the file is
+// still not linked to a generator and does not exist, every package is loaded.
+func (proj *Project) requireLabel(ctx context.Context, l *label.Label) error {
+	if !proj.lazy || l.Project != "" {
+		return nil
+	}
+
+	switch {
+	case IsTarget(l):
+		return proj.requirePackage(ctx, l.Package)
+	case IsSource(l):
+		return proj.requireSource(ctx, l)
+	default:
+		return nil
+	}
+}
+
+// requireSource loads the packages that may define the generator of the given source file.
+func (proj *Project) requireSource(ctx context.Context, l *label.Label) error {
+	for pkg := l.Package; ; pkg = label.Parent(pkg) {
+		if _, err := os.Stat(filepath.Join(proj.root, pkg[2:], "BUILD.dawn")); err == nil {
+			if err := proj.requirePackage(ctx, pkg); err != nil {
+				return err
+			}
+			break
+		}
+		if pkg == "//" {
+			break
+		}
+	}
+
+	rel := path.Join(l.Package[2:], l.Name)
+	if proj.generated(rel) {
+		return nil
+	}
+
+	proj.m.Lock()
+	generator, ok := proj.generators[rel]
+	proj.m.Unlock()
+	if ok {
+		if gen, err := label.Parse(generator); err == nil {
+			if err := proj.requirePackage(ctx, gen.Package); err != nil {
+				return err
+			}
+			if proj.generated(rel) {
+				return nil
+			}
+		}
+	}
+
+	// A missing file may be generated by a target in any package.
+	if _, err := os.Lstat(filepath.Join(proj.root, filepath.FromSlash(rel))); !errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return proj.requirePackages(ctx, "//")
+}
+
+// generated returns true if the source file at the given project-relative path is linked to its
+// generator.
+func (proj *Project) generated(rel string) bool {
+	l, err := sourceLabel("//", rel)
+	if err != nil {
+		return false
+	}
+
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	t, ok := proj.targets[l.String()]
+	if !ok {
+		return false
+	}
+	f, ok := t.target.(*sourceFile)
+	return ok && f.generator != nil
+}
+
+// requirePattern loads the packages whose targets may be matched by the given pattern.
+func (proj *Project) requirePattern(ctx context.Context, pattern *label.Label) error {
+	if !proj.lazy || !pattern.IsPattern() {
+		return nil
+	}
+
+	base := strings.TrimSuffix(pattern.Package, "...")
+	if base == pattern.Package {
+		return proj.requirePackage(ctx, base)
+	}
+	if base != "//" {
+		base = strings.TrimSuffix(base, "/")
+	}
+	return proj.requirePackages(ctx, base)
+}
+
+// requirePackages loads the package with the given path and all of its subpackages.
+func (proj *Project) requirePackages(ctx context.Context, base string) error {
+	var m sync.Mutex
+	var errs []error
+	err := proj.forEachPackage(base, func(pkg string) {
+		if err := proj.requirePackage(ctx, pkg); err != nil {
+			m.Lock()
+			errs = append(errs, err)
+			m.Unlock()
+		}
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return errors.Join(append(errs, err)...)
+}
+
+// loadGenerators loads the generators recorded for the project's generated source files by
+// earlier runs. If the record does not exist or cannot be read, no generators are known.
+func (proj *Project) loadGenerators() {
+	proj.generators = map[string]string{}
+
+	//nolint:gosec
+	f, err := os.Open(filepath.Join(proj.work, "generators.json"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var generators map[string]string
+	if err := sonnet.NewDecoder(f).Decode(&generators); err == nil && generators != nil {
+		proj.generators = generators
+	}
+}
+
+// saveGenerators records the generator of each of the project's generated source files so that
+// later lazy loads can find generators that are defined outside of the packages that contain
+// their files. Records for generators that were not loaded are retained.
+func (proj *Project) saveGenerators() error {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	generators := map[string]string{}
+	for rel, generator := range proj.generators {
+		if proj.retainGenerator(generator) {
+			generators[rel] = generator
+		}
+	}
+	for _, t := range proj.targets {
+		for _, g := range t.target.generates() {
+			generators[proj.relPath(g)] = t.target.Label().String()
+		}
+	}
+	if maps.Equal(generators, proj.generators) {
+		return nil
+	}
+
+	if err := os.MkdirAll(proj.temp, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(proj.temp, "")
+	if err != nil {
+		return err
+	}
+	tempName := f.Name()
+
+	if err = sonnet.NewEncoder(f).Encode(generators); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tempName, filepath.Join(proj.work, "generators.json")); err != nil {
+		return err
+	}
+
+	proj.generators = generators
+	return nil
+}
+
+// retainGenerator returns true if the recorded generator with the given label must be retained
+// because its definition is unknown, i.e. because it was loaded from the index or is defined in
+// a package that a lazy project has not loaded. proj.m must be held.
+func (proj *Project) retainGenerator(generator string) bool {
+	if t, ok := proj.targets[generator]; ok {
+		_, index := t.target.(*indexTarget)
+		return index
+	}
+	if !proj.lazy {
+		return false
+	}
+	l, err := label.Parse(generator)
+	if err != nil {
+		return false
+	}
+	_, loaded := proj.packages[l.Package]
+	return !loaded
+}
@@ -0,0 +1,196 @@
+package dawn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pgavlin/dawn/label"
+)
+
+// reloadChanged reloads the project after the given files changed. Only the modules that were
+// affected by the changes are executed again; the flags and targets defined by the remaining
+// modules are retained as-is. If the changes may have added or removed packages, or if the
+// project is lazy or was not loaded successfully, the entire project is reloaded.
+func (proj *Project) reloadChanged(ctx context.Context, changed map[string]struct{}) error {
+	affected, ok := proj.affectedModules(changed)
+	if !ok {
+		return proj.Reload(ctx)
+	}
+	return proj.reloadModules(ctx, affected)
+}
+
+// affectedModules returns the labels of the modules that were affected by changes to the given
+// files. A module is affected if its source changed, if the results of any of its calls to glob
+// changed, or if it transitively loads an affected module. The second result is false if the
+// project must be reloaded in its entirety.
+func (proj *Project) affectedModules(changed map[string]struct{}) (map[string]bool, bool) {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	if proj.lazy || proj.config == "" {
+		return nil, false
+	}
+
+	paths := make(map[string]bool, len(proj.modules))
+	for _, m := range proj.modules {
+		if m.err != nil {
+			return nil, false
+		}
+		paths[m.path] = true
+	}
+
+	// Changes to the set of packages or to .gitignore files require a full reload, as do new or
+	// removed directories, which may contain packages.
+	for path := range changed {
+		switch filepath.Base(path) {
+		case "BUILD.dawn":
+			if _, err := os.Stat(path); err != nil || !paths[path] {
+				return nil, false
+			}
+		case ".gitignore":
+			return nil, false
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return nil, false
+		}
+		prefix := path + string(filepath.Separator)
+		for p := range paths {
+			if strings.HasPrefix(p, prefix) {
+				return nil, false
+			}
+		}
+	}
+
+	affected := map[string]bool{}
+	for label, m := range proj.modules {
+		// External modules are immutable.
+		if m.label.Project != "" {
+			continue
+		}
+		if _, ok := changed[m.path]; ok || m.globsChanged(changed) {
+			affected[label] = true
+		}
+	}
+	proj.addLoaders(affected)
+	return affected, true
+}
+
+// addLoaders adds the labels of the modules that transitively load any of the given modules to
+// the given set.
+func (proj *Project) addLoaders(modules map[string]bool) {
+	for done := false; !done; {
+		done = true
+		for label, m := range proj.modules {
+			if modules[label] {
+				continue
+			}
+			for _, dep := range m.dependencies {
+				if modules[dep] {
+					modules[label], done = true, false
+					break
+				}
+			}
+		}
+	}
+}
+
+// globsChanged returns true if the results of any of the module's calls to glob may have been
+// changed by changes to the given files.
+func (m *module) globsChanged(changed map[string]struct{}) bool {
+	dir := filepath.Dir(m.path)
+	if !containsChanged(changed, dir) {
+		return false
+	}
+
+	for _, g := range m.globs {
+		paths, err := globPaths(dir, g.Include, g.Exclude, g.Dirs)
+		if err != nil || globSum(paths) != g.Sum {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadModules executes the given modules again. The flags and targets defined by the modules
+// are discarded before the modules are executed.
+func (proj *Project) reloadModules(ctx context.Context, affected map[string]bool) (err error) {
+	defer func() {
+		proj.events.LoadDone(err)
+	}()
+
+	config := proj.config
+
+	proj.m.Lock()
+	proj.config = ""
+	var packages []string
+	for label := range affected {
+		m := proj.modules[label]
+		delete(proj.modules, label)
+		for name := range m.flags {
+			delete(proj.flags, name)
+		}
+		if m.label.Project == "" && m.label.Name == "BUILD.dawn" {
+			packages = append(packages, m.label.Package)
+		}
+	}
+	retained := make(map[string]bool, len(proj.targets))
+	for label, t := range proj.targets {
+		if f, ok := t.target.(*function); ok && affected[f.module.label.String()] {
+			delete(proj.targets, label)
+		} else {
+			retained[label] = true
+		}
+	}
+	proj.m.Unlock()
+
+	proj.forEach(packages, func(pkg string) {
+		_, _ = proj.loadModule(ctx, nil, &label.Label{Kind: "module", Package: pkg, Name: "BUILD.dawn"})
+	})
+	for _, m := range proj.modules {
+		if m.err != nil {
+			return m.err
+		}
+	}
+
+	// Discard the source files that are no longer referenced by any function and unlink the
+	// remaining source files from their generators, which may have been redefined.
+	referenced := map[string]bool{}
+	for _, t := range proj.targets {
+		if f, ok := t.target.(*function); ok {
+			for _, dep := range f.deps {
+				referenced[dep] = true
+			}
+		}
+	}
+	for label, t := range proj.targets {
+		if f, ok := t.target.(*sourceFile); ok {
+			if !referenced[label] {
+				delete(proj.targets, label)
+				continue
+			}
+			f.generator = nil
+		}
+	}
+
+	if err := proj.link(); err != nil {
+		return err
+	}
+	proj.prunePrograms()
+
+	// Load the information for the new targets. If the configuration changed, the information
+	// for every target must be loaded again.
+	proj.config = configHash(proj.flags)
+	for label, t := range proj.targets {
+		if retained[label] && proj.config == config {
+			continue
+		}
+		if err := t.target.load(); err != nil {
+			return err
+		}
+		t.data = t.target.info().Data
+	}
+
+	return proj.saveIndex()
+}
@@ -0,0 +1,150 @@
+package dawn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// A service is a running instance of a service target.
+type service struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// A serviceSet supervises the running instances of a project's service targets.
+type serviceSet struct {
+	m        sync.Mutex
+	services map[string]*service
+}
+
+func newServiceSet() *serviceSet {
+	return &serviceSet{services: map[string]*service{}}
+}
+
+// running returns true if an instance of the service with the given label is running.
+func (s *serviceSet) running(label string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	_, ok := s.services[label]
+	return ok
+}
+
+// start stops the running instance of the given service, if any, then starts a new instance in
+// the background. The instance runs until its callback returns or it is stopped; cancelling the
+// given context does not stop the instance.
+func (s *serviceSet) start(ctx context.Context, f *function) {
+	label := f.label.String()
+	s.stop(label)
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	svc := &service{cancel: cancel, done: make(chan struct{})}
+
+	s.m.Lock()
+	s.services[label] = svc
+	s.m.Unlock()
+
+	go func() {
+		defer close(svc.done)
+
+		err := f.callback(ctx, nil)
+
+		s.m.Lock()
+		if s.services[label] == svc {
+			delete(s.services, label)
+		}
+		s.m.Unlock()
+
+		switch {
+		case ctx.Err() != nil:
+			// The service was stopped.
+		case err != nil:
+			f.proj.events.Print(f.label, fmt.Sprintf("service failed: %v", err))
+		default:
+			f.proj.events.Print(f.label, "service exited")
+		}
+	}()
+}
+
+// stop stops the running instance of the service with the given label, if any, and waits for it
+// to exit.
+func (s *serviceSet) stop(label string) {
+	s.m.Lock()
+	svc, ok := s.services[label]
+	delete(s.services, label)
+	s.m.Unlock()
+
+	if ok {
+		svc.cancel()
+		<-svc.done
+	}
+}
+
+// stopRemovedServices stops the running services that are no longer defined by the project.
+func (proj *Project) stopRemovedServices() {
+	proj.m.Lock()
+	var removed []string
+	for _, label := range proj.services.labels() {
+		if t, ok := proj.targets[label]; !ok || !isService(t.target) {
+			removed = append(removed, label)
+		}
+	}
+	proj.m.Unlock()
+
+	for _, label := range removed {
+		proj.services.stop(label)
+	}
+}
+
+// isService returns true if the given target is a service.
+func isService(t Target) bool {
+	f, ok := t.(*function)
+	return ok && f.service
+}
+
+// labels returns the labels of the running services.
+func (s *serviceSet) labels() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	labels := make([]string, 0, len(s.services))
+	for label := range s.services {
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// stopAll stops every running service and waits for them to exit.
+func (s *serviceSet) stopAll() {
+	s.m.Lock()
+	services := s.services
+	s.services = map[string]*service{}
+	s.m.Unlock()
+
+	for _, svc := range services {
+		svc.cancel()
+	}
+	for _, svc := range services {
+		<-svc.done
+	}
+}
+
+// wait waits for every running service to exit or for the given context to be cancelled, then
+// stops any services that are still running.
+func (s *serviceSet) wait(ctx context.Context) {
+	s.m.Lock()
+	services := make([]*service, 0, len(s.services))
+	for _, svc := range s.services {
+		services = append(services, svc)
+	}
+	s.m.Unlock()
+
+	for _, svc := range services {
+		select {
+		case <-svc.done:
+		case <-ctx.Done():
+		}
+	}
+	s.stopAll()
+}
@@ -4,17 +4,27 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/otiai10/copy"
 	"github.com/pgavlin/dawn/diff"
+	"github.com/pgavlin/dawn/internal/project"
 	"github.com/pgavlin/dawn/label"
 	starlark_os "github.com/pgavlin/dawn/lib/os"
 	starlark_sh "github.com/pgavlin/dawn/lib/sh"
+	"github.com/pgavlin/dawn/runner"
 	starlark_json "github.com/pgavlin/starlark-go/lib/json"
 	"github.com/pgavlin/starlark-go/starlark"
 	"github.com/stretchr/testify/assert"
@@ -50,6 +60,10 @@ func (e *testEvents) RequirementLoadFailed(label *label.Label, version string, e
 	e.event("RequirementLoadFailed", label, "version", version, "err", err)
 }
 
+func (e *testEvents) PackagesDiscovered(packages, dirs int, elapsed time.Duration) {
+	e.event("PackagesDiscovered", nil, "packages", packages, "dirs", dirs)
+}
+
 func (e *testEvents) ModuleLoading(label *label.Label) {
 	e.event("ModuleLoading", label)
 }
@@ -78,6 +92,18 @@ func (e *testEvents) TargetEvaluating(label *label.Label, reason string, diff di
 	e.event("TargetEvaluating", label, "reason", reason, "diff", diff)
 }
 
+func (e *testEvents) TargetCacheHit(label *label.Label, remote bool) {
+	e.event("TargetCacheHit", label, "remote", remote)
+}
+
+func (e *testEvents) TargetCacheMiss(label *label.Label) {
+	e.event("TargetCacheMiss", label)
+}
+
+func (e *testEvents) TargetRetrying(label *label.Label, attempt int, err error) {
+	e.event("TargetRetrying", label, "attempt", attempt, "err", err)
+}
+
 func (e *testEvents) TargetFailed(label *label.Label, err error) {
 	e.event("TargetFailed", label, "err", err)
 }
@@ -94,6 +120,10 @@ func (e *testEvents) FileChanged(label *label.Label) {
 	e.event("FileChanged", label)
 }
 
+func (e *testEvents) WatchPolling(interval time.Duration, err error) {
+	e.event("WatchPolling", nil, "interval", interval, "err", err)
+}
+
 func (e *testEvents) event(kind string, label *label.Label, pairs ...interface{}) {
 	e.m.Lock()
 	defer e.m.Unlock()
@@ -111,11 +141,35 @@ func (e *testEvents) event(kind string, label *label.Label, pairs ...interface{}
 	e.events = append(e.events, event)
 }
 
+// evaluatingReason returns the reason the target with the given label was evaluated, or the empty
+// string if the target was not evaluated.
+func evaluatingReason(events []testEvent, l *label.Label) string {
+	for _, e := range events {
+		if e["kind"] == "TargetEvaluating" && e["label"].(*label.Label).String() == l.String() {
+			return e["reason"].(string)
+		}
+	}
+	return ""
+}
+
+// buildTarget loads the project at the given root, builds the target with the given label, and
+// returns the reason the target was evaluated, if any. The options' Events are replaced.
+func buildTarget(t *testing.T, root string, options LoadOptions, l *label.Label) string {
+	events := &testEvents{}
+	options.Events = events
+
+	proj, err := Load(t.Context(), root, &options)
+	require.NoError(t, err)
+	require.NoError(t, proj.Run(t.Context(), []*label.Label{l}, nil))
+	return evaluatingReason(events.events, l)
+}
+
 type projectTest struct {
 	path     string
 	edits    []string
 	loadErr  string
 	runErr   string
+	options  func(options *LoadOptions)
 	validate func(t *testing.T, dir string, events []testEvent)
 }
 
@@ -145,7 +199,8 @@ func (pt *projectTest) run(t *testing.T) {
 
 	events := &testEvents{}
 	options := &LoadOptions{
-		Events: events,
+		Events:      events,
+		ActionCache: t.TempDir(),
 		Builtins: starlark.StringDict{
 			"cancel": cancelBuiltin,
 			"json":   starlark_json.Module,
@@ -153,6 +208,9 @@ func (pt *projectTest) run(t *testing.T) {
 			"sh":     starlark_sh.Module,
 		},
 	}
+	if pt.options != nil {
+		pt.options(options)
+	}
 
 	for _, p := range paths {
 		err = copy.Copy(p, temp, copy.Options{OnDirExists: func(_, _ string) copy.DirExistsAction {
@@ -170,7 +228,7 @@ func (pt *projectTest) run(t *testing.T) {
 		err = proj.GC()
 		require.NoError(t, err)
 
-		err = proj.Run(ctx, def, nil)
+		err = proj.Run(ctx, []*label.Label{def}, nil)
 		if pt.runErr != "" {
 			assert.ErrorContains(t, err, pt.runErr)
 			return
@@ -317,6 +375,28 @@ func TestInvalidTargetName(t *testing.T) {
 	pt.run(t)
 }
 
+func TestResources(t *testing.T) {
+	t.Parallel()
+	pt := projectTest{
+		path: "testdata/resources",
+		validate: func(t *testing.T, dir string, _ []testEvent) {
+			for _, name := range []string{"a", "b", "c"} {
+				assert.Equal(t, []byte(name+"\n"), readFile(t, filepath.Join(dir, name+".txt")))
+			}
+		},
+	}
+	pt.run(t)
+}
+
+func TestUnknownResourcePool(t *testing.T) {
+	t.Parallel()
+	pt := projectTest{
+		path:    "testdata/unknown-resource-pool",
+		loadErr: `unknown resource pool "gpu"`,
+	}
+	pt.run(t)
+}
+
 func TestZeroLengthSource(t *testing.T) {
 	t.Parallel()
 	pt := projectTest{
@@ -325,3 +405,1170 @@ func TestZeroLengthSource(t *testing.T) {
 	}
 	pt.run(t)
 }
+
+func TestEarlyCutoff(t *testing.T) {
+	t.Parallel()
+	pt := projectTest{
+		path:  "testdata/early-cutoff",
+		edits: []string{"edit1", "edit2"},
+		validate: func(t *testing.T, dir string, _ []testEvent) {
+			expected := readFile(t, filepath.Join(dir, "expected.txt"))
+			assert.Equal(t, expected, readFile(t, filepath.Join(dir, "deps.txt")))
+			assert.Equal(t, expected, readFile(t, filepath.Join(dir, "sources.txt")))
+		},
+	}
+	pt.run(t)
+}
+
+func TestActionCache(t *testing.T) {
+	t.Parallel()
+	pt := projectTest{
+		path:  "testdata/action-cache",
+		edits: []string{"edit1", "edit2"},
+		validate: func(t *testing.T, dir string, _ []testEvent) {
+			expected := readFile(t, filepath.Join(dir, "expected.txt"))
+			assert.Equal(t, expected, readFile(t, filepath.Join(dir, "out.txt")))
+			assert.Equal(t, expected, readFile(t, filepath.Join(dir, "copy.txt")))
+
+			expected = readFile(t, filepath.Join(dir, "expected-runs.txt"))
+			assert.Equal(t, expected, readFile(t, filepath.Join(dir, "runs.txt")))
+		},
+	}
+	pt.run(t)
+}
+
+type testRemoteCache struct {
+	m       sync.Mutex
+	entries map[string][]byte
+}
+
+func (c *testRemoteCache) len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return len(c.entries)
+}
+
+func (c *testRemoteCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		contents, ok := c.entries[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(contents)
+	case http.MethodPut:
+		contents, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.entries[r.URL.Path] = contents
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRemoteCache(t *testing.T) {
+	t.Parallel()
+
+	cache := &testRemoteCache{entries: map[string][]byte{}}
+	server := httptest.NewServer(cache)
+	defer server.Close()
+
+	cacheEvents := func(events []testEvent) []testEvent {
+		return slices.DeleteFunc(slices.Clone(events), func(e testEvent) bool {
+			kind := e["kind"].(string)
+			return kind != "TargetCacheHit" && kind != "TargetCacheMiss"
+		})
+	}
+
+	// A read-only cache is never written.
+	pt := projectTest{
+		path: "testdata/remote-cache",
+		options: func(options *LoadOptions) {
+			options.RemoteCache = server.URL
+		},
+		validate: func(t *testing.T, dir string, events []testEvent) {
+			assert.Len(t, cacheEvents(events), 2)
+			assert.Zero(t, cache.len())
+		},
+	}
+	pt.run(t)
+
+	// A write-through cache is populated with the results of each target.
+	pt.options = func(options *LoadOptions) {
+		options.RemoteCache, options.RemoteCacheMode = server.URL, project.CacheWriteThrough
+	}
+	pt.validate = func(t *testing.T, dir string, events []testEvent) {
+		for _, e := range cacheEvents(events) {
+			assert.Equal(t, "TargetCacheMiss", e["kind"])
+		}
+		assert.Equal(t, []byte("hello\n"), readFile(t, filepath.Join(dir, "runs.txt")))
+		assert.NotZero(t, cache.len())
+	}
+	pt.run(t)
+
+	// A fresh workspace restores the results of each target from the remote cache.
+	pt.options = func(options *LoadOptions) {
+		options.RemoteCache = server.URL
+	}
+	pt.validate = func(t *testing.T, dir string, events []testEvent) {
+		hits := cacheEvents(events)
+		require.Len(t, hits, 2)
+		for _, e := range hits {
+			assert.Equal(t, "TargetCacheHit", e["kind"])
+			assert.Equal(t, true, e["remote"])
+		}
+
+		assert.Equal(t, []byte("hello\n"), readFile(t, filepath.Join(dir, "out.txt")))
+		assert.Equal(t, []byte("hello\n"), readFile(t, filepath.Join(dir, "copy.txt")))
+		assert.NoFileExists(t, filepath.Join(dir, "runs.txt"))
+	}
+	pt.run(t)
+
+	// Results with malformed output sums are treated as misses.
+	cache.m.Lock()
+	for path := range cache.entries {
+		if strings.HasPrefix(path, "/ac/") {
+			cache.entries[path] = []byte(`{"data":"","outputs":{"out.txt":{"sum":"../../x","mode":420},"copy.txt":{"sum":"","mode":420}}}`)
+		}
+	}
+	cache.m.Unlock()
+
+	pt.validate = func(t *testing.T, dir string, events []testEvent) {
+		for _, e := range cacheEvents(events) {
+			assert.Equal(t, "TargetCacheMiss", e["kind"])
+		}
+		assert.Equal(t, []byte("hello\n"), readFile(t, filepath.Join(dir, "out.txt")))
+		assert.Equal(t, []byte("hello\n"), readFile(t, filepath.Join(dir, "copy.txt")))
+		assert.Equal(t, []byte("hello\n"), readFile(t, filepath.Join(dir, "runs.txt")))
+	}
+	pt.run(t)
+}
+
+func TestRemoteCacheTimeout(t *testing.T) {
+	t.Parallel()
+
+	// The server never responds before the request times out.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	remote, err := newRemoteCache(server.URL, project.CacheWriteThrough)
+	require.NoError(t, err)
+	assert.Equal(t, remoteCacheTimeout, remote.client.Timeout)
+	remote.client.Timeout = 50 * time.Millisecond
+
+	// A timed-out read is a cache miss.
+	cache := &actionCache{dir: t.TempDir(), remote: remote}
+	_, _, ok := cache.load(t.Context(), strings.Repeat("0", 64))
+	assert.False(t, ok)
+
+	// A timed-out write fails rather than blocking.
+	err = remote.put(t.Context(), "ac", strings.Repeat("0", 64), strings.NewReader("{}"), 2)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestConfigurations(t *testing.T) {
+	t.Parallel()
+
+	def, err := label.Parse("//:default")
+	require.NoError(t, err)
+
+	temp := t.TempDir()
+	err = copy.Copy("testdata/configurations", temp)
+	require.NoError(t, err)
+
+	// Switching back to a configuration that has already been built should not re-run any
+	// targets. The outputs of targets that generate files are restored from the action cache.
+	cache := t.TempDir()
+	for i, mode := range []string{"debug", "release", "debug", "release"} {
+		events := &testEvents{}
+		proj, err := Load(t.Context(), temp, &LoadOptions{
+			Args:        []string{"--mode=" + mode},
+			Events:      events,
+			Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+			ActionCache: cache,
+		})
+		require.NoError(t, err)
+
+		err = proj.GC()
+		require.NoError(t, err)
+
+		err = proj.Run(t.Context(), []*label.Label{def}, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []byte(mode+"\n"), readFile(t, filepath.Join(temp, "out.txt")))
+		if i >= 2 {
+			hits := slices.DeleteFunc(slices.Clone(events.events), func(e testEvent) bool {
+				return e["kind"] != "TargetCacheHit"
+			})
+			require.Len(t, hits, 1)
+			assert.Equal(t, "//:out", hits[0]["label"].(*label.Label).String())
+		}
+	}
+
+	assert.Equal(t, []byte("debug\nrelease\n"), readFile(t, filepath.Join(temp, "out-runs.txt")))
+	assert.Equal(t, []byte("debug\nrelease\n"), readFile(t, filepath.Join(temp, "runs.txt")))
+}
+
+func TestProgramCache(t *testing.T) {
+	t.Parallel()
+
+	def, err := label.Parse("//:default")
+	require.NoError(t, err)
+
+	temp := t.TempDir()
+	err = copy.Copy("testdata/configurations", temp)
+	require.NoError(t, err)
+
+	options := &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	}
+
+	proj, err := Load(t.Context(), temp, options)
+	require.NoError(t, err)
+	require.Len(t, proj.programs, 1)
+	programs := maps.Clone(proj.programs)
+
+	// Compiled programs are cached on disk.
+	for key := range programs {
+		assert.FileExists(t, proj.programPath(key))
+	}
+
+	err = proj.Run(t.Context(), []*label.Label{def}, nil)
+	require.NoError(t, err)
+
+	// Reloading an unchanged module reuses its compiled program.
+	err = proj.Reload(t.Context())
+	require.NoError(t, err)
+	for key, prog := range programs {
+		assert.Same(t, prog, proj.programs[key])
+	}
+
+	// A new project decodes the cached program. The environments of the functions in a decoded
+	// program match those in a freshly-compiled program, so the target is not re-run.
+	proj, err = Load(t.Context(), temp, options)
+	require.NoError(t, err)
+	err = proj.Run(t.Context(), []*label.Label{def}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("release\n"), readFile(t, filepath.Join(temp, "runs.txt")))
+
+	// A corrupt entry is compiled again and replaced.
+	for key := range programs {
+		err = os.WriteFile(proj.programPath(key), []byte("garbage"), 0o600)
+		require.NoError(t, err)
+	}
+	proj, err = Load(t.Context(), temp, options)
+	require.NoError(t, err)
+	for key := range programs {
+		_, ok := proj.loadProgram(key)
+		assert.True(t, ok)
+	}
+
+	// Reloading a changed module compiles it again and discards the old program. The old program's
+	// entry is removed from disk by GC.
+	err = os.WriteFile(filepath.Join(temp, "BUILD.dawn"), []byte("mode = \"debug\"\n"), 0o600)
+	require.NoError(t, err)
+	err = proj.Reload(t.Context())
+	require.NoError(t, err)
+	require.Len(t, proj.programs, 1)
+	for key := range programs {
+		assert.NotContains(t, proj.programs, key)
+	}
+
+	err = proj.GC()
+	require.NoError(t, err)
+	for key := range programs {
+		assert.NoFileExists(t, proj.programPath(key))
+	}
+	for key := range proj.programs {
+		assert.FileExists(t, proj.programPath(key))
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	t.Parallel()
+
+	def, err := label.Parse("//:default")
+	require.NoError(t, err)
+
+	temp := t.TempDir()
+	err = copy.Copy("testdata/keep-going", temp)
+	require.NoError(t, err)
+
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	err = proj.Run(t.Context(), []*label.Label{def}, &RunOptions{KeepGoing: true})
+	var runErr *RunError
+	require.ErrorAs(t, err, &runErr)
+
+	require.Len(t, runErr.Failures, 1)
+	failure := runErr.Failures[0]
+	assert.Equal(t, "//:broken", failure.Label)
+	assert.ErrorContains(t, failure.Err, "broken")
+	assert.Equal(t, []string{"//:blocked", "//:default"}, failure.Blocked)
+
+	assert.Equal(t, []byte("ok\n"), readFile(t, filepath.Join(temp, "ok.txt")))
+}
+
+func TestPatterns(t *testing.T) {
+	t.Parallel()
+
+	parse := func(raw string) *label.Label {
+		l, err := label.ParsePattern(raw)
+		require.NoError(t, err)
+		return l
+	}
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/patterns", temp)
+	require.NoError(t, err)
+
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	err = proj.Run(t.Context(), []*label.Label{parse("//c/...")}, nil)
+	assert.ErrorContains(t, err, "no targets match //c/...")
+
+	err = proj.Run(t.Context(), []*label.Label{parse("//a/..."), parse("//b:shared")}, nil)
+	require.NoError(t, err)
+
+	for _, f := range []string{"a/x.txt", "a/y.txt", "a/sub/z.txt"} {
+		assert.FileExists(t, filepath.Join(temp, f))
+	}
+	for _, f := range []string{"top.txt", "b/other.txt"} {
+		assert.NoFileExists(t, filepath.Join(temp, f))
+	}
+
+	// The shared dependency is only built once.
+	assert.Equal(t, []byte("shared\n"), readFile(t, filepath.Join(temp, "b", "shared.log")))
+}
+
+func TestDurations(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/durations", temp)
+	require.NoError(t, err)
+
+	cache := t.TempDir()
+	load := func(events Events) *Project {
+		proj, err := Load(t.Context(), temp, &LoadOptions{
+			Events:      events,
+			Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+			ActionCache: cache,
+		})
+		require.NoError(t, err)
+		return proj
+	}
+
+	proj := load(nil)
+	assert.Zero(t, proj.EstimateDuration("//:gen"))
+
+	broken, err := label.Parse("//:broken")
+	require.NoError(t, err)
+	err = proj.Run(t.Context(), []*label.Label{broken}, &RunOptions{Schedule: runner.ScheduleFIFO})
+	require.Error(t, err)
+
+	// The duration of each evaluated target is recorded. Failed targets have no duration.
+	proj = load(nil)
+	duration := proj.EstimateDuration("//:gen")
+	assert.NotZero(t, duration)
+	assert.Zero(t, proj.EstimateDuration("//:broken"))
+
+	err = proj.Run(t.Context(), []*label.Label{broken}, &RunOptions{Schedule: "lifo"})
+	assert.ErrorContains(t, err, `unknown schedule "lifo"`)
+
+	// A target whose results are restored from the action cache retains the duration of its
+	// last run.
+	err = os.Remove(filepath.Join(temp, "out.txt"))
+	require.NoError(t, err)
+
+	events := &testEvents{}
+	proj = load(events)
+	gen, err := label.Parse("//:gen")
+	require.NoError(t, err)
+	err = proj.Run(t.Context(), []*label.Label{gen}, nil)
+	require.NoError(t, err)
+	assert.True(t, slices.ContainsFunc(events.events, func(e testEvent) bool { return e["kind"] == "TargetCacheHit" }))
+
+	proj = load(nil)
+	assert.Equal(t, duration, proj.EstimateDuration("//:gen"))
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/retries", temp)
+	require.NoError(t, err)
+
+	events := &testEvents{}
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Events:      events,
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	retries := func(name string) []any {
+		var attempts []any
+		for _, e := range events.events {
+			if e["kind"] == "TargetRetrying" && e["label"].(*label.Label).Name == name {
+				attempts = append(attempts, e["attempt"])
+			}
+		}
+		return attempts
+	}
+
+	// A target that fails is retried.
+	flaky, err := label.Parse("//:flaky")
+	require.NoError(t, err)
+	err = proj.Run(t.Context(), []*label.Label{flaky}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []any{1}, retries("flaky"))
+
+	// A target that times out is cancelled and retried.
+	hang, err := label.Parse("//:hang")
+	require.NoError(t, err)
+	err = proj.Run(t.Context(), []*label.Label{hang}, nil)
+	var timeoutErr *TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.ErrorContains(t, err, "timed out after 100ms (after 2 attempts)")
+	assert.Equal(t, []any{1}, retries("hang"))
+}
+
+func TestInvalidate(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/invalidate", temp)
+	require.NoError(t, err)
+
+	events := &testEvents{}
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Events:      events,
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	all, err := label.Parse("//:all")
+	require.NoError(t, err)
+	targets := []*label.Label{all}
+	require.NoError(t, proj.Run(t.Context(), targets, nil))
+
+	// rebuild reloads the project, invalidates the targets affected by the given files, and
+	// returns the names of the targets that were visited and evaluated by the subsequent run.
+	rebuild := func(changed ...string) (visited, evaluated []string) {
+		snapshot := proj.snapshot()
+		require.NoError(t, proj.Reload(t.Context()))
+
+		paths := map[string]struct{}{}
+		for _, path := range changed {
+			paths[filepath.Join(temp, path)] = struct{}{}
+		}
+		proj.invalidate(snapshot, paths)
+
+		start := len(events.events)
+		require.NoError(t, proj.Run(t.Context(), targets, nil))
+
+		for _, e := range events.events[start:] {
+			switch e["kind"] {
+			case "TargetUpToDate":
+				visited = append(visited, e["label"].(*label.Label).Name)
+			case "TargetEvaluating":
+				visited = append(visited, e["label"].(*label.Label).Name)
+				evaluated = append(evaluated, e["label"].(*label.Label).Name)
+			}
+		}
+		slices.Sort(visited)
+		slices.Sort(evaluated)
+		return visited, evaluated
+	}
+
+	// Only the targets that depend on a changed source are revisited.
+	require.NoError(t, os.WriteFile(filepath.Join(temp, "a.txt"), []byte("changed\n"), 0o600))
+	visited, evaluated := rebuild("a.txt")
+	assert.Equal(t, []string{"a.out", "a.txt", "a_gen", "a_use", "all"}, visited)
+	assert.Equal(t, []string{"a.txt", "a_gen"}, evaluated)
+
+	// Nothing is revisited if nothing changed.
+	visited, evaluated = rebuild()
+	assert.Empty(t, visited)
+	assert.Empty(t, evaluated)
+
+	// Changing a module revisits each of its targets.
+	visited, evaluated = rebuild("BUILD.dawn")
+	assert.Equal(t, []string{"a.out", "a_gen", "a_use", "all", "b.out", "b_gen", "b_use"}, visited)
+	assert.Empty(t, evaluated)
+
+	// A target that is always run is revisited by every rebuild, even if nothing changed.
+	stamp, err := label.Parse("//:stamp")
+	require.NoError(t, err)
+	targets = append(targets, stamp)
+	require.NoError(t, proj.Run(t.Context(), targets, nil))
+
+	visited, evaluated = rebuild()
+	assert.Equal(t, []string{"stamp"}, visited)
+	assert.Equal(t, []string{"stamp"}, evaluated)
+}
+
+func TestLazy(t *testing.T) {
+	t.Parallel()
+
+	parse := func(raw string) *label.Label {
+		l, err := label.ParsePattern(raw)
+		require.NoError(t, err)
+		return l
+	}
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/lazy", temp)
+	require.NoError(t, err)
+
+	// Loading the entire project fails.
+	_, err = Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	})
+	require.ErrorContains(t, err, "c must not be loaded")
+
+	// Loading the project lazily only loads the packages that are required.
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+		Lazy:        true,
+	})
+	require.NoError(t, err)
+
+	err = proj.Run(t.Context(), []*label.Label{parse("//:default")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b\n"), readFile(t, filepath.Join(temp, "b", "out.txt")))
+
+	var targets []string
+	for _, t := range proj.Targets() {
+		targets = append(targets, t.Label().String())
+	}
+	assert.Equal(t, []string{"//:build", "//:default", "//a:a", "//b:b", "source://b:out.txt"}, targets)
+
+	// Patterns load the packages they match.
+	err = proj.Run(t.Context(), []*label.Label{parse("//a/...")}, nil)
+	require.NoError(t, err)
+
+	err = proj.Run(t.Context(), []*label.Label{parse("//...")}, nil)
+	require.ErrorContains(t, err, "c must not be loaded")
+}
+
+func TestLazyGenerators(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/lazy-generators", temp)
+	require.NoError(t, err)
+
+	use, err := label.Parse("//c:use")
+	require.NoError(t, err)
+
+	options := LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+		Lazy:        true,
+	}
+
+	// A missing source file that is generated by a target in another package is found by loading
+	// every package.
+	buildTarget(t, temp, options, use)
+	assert.Equal(t, []byte("v1\n"), readFile(t, filepath.Join(temp, "b", "x.txt")))
+	assert.Equal(t, []byte("v1\n"), readFile(t, filepath.Join(temp, "c", "out.txt")))
+
+	// Once the file exists, its generator is found using the generator recorded by the last run,
+	// so the file is regenerated rather than used as-is.
+	err = os.WriteFile(filepath.Join(temp, "a", "input.txt"), []byte("v2\n"), 0o600)
+	require.NoError(t, err)
+	buildTarget(t, temp, options, use)
+	assert.Equal(t, []byte("v2\n"), readFile(t, filepath.Join(temp, "c", "out.txt")))
+}
+
+func TestDiscovery(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/discovery", temp)
+	require.NoError(t, err)
+
+	// Packages inside .git are never loaded.
+	err = os.MkdirAll(filepath.Join(temp, ".git", "objects"), 0o750)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(temp, ".git", "objects", "BUILD.dawn"), []byte("fail(\".git must not be loaded\")\n"), 0o600)
+	require.NoError(t, err)
+
+	events := &testEvents{}
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		Events:      events,
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	var targets []string
+	for _, t := range proj.Targets() {
+		targets = append(targets, t.Label().String())
+	}
+	assert.Equal(t, []string{"//:build", "//:default", "//a:a"}, targets)
+
+	var discovered []testEvent
+	for _, e := range events.events {
+		if e["kind"] == "PackagesDiscovered" {
+			discovered = append(discovered, e)
+		}
+	}
+	require.Len(t, discovered, 1)
+	assert.Equal(t, 2, discovered[0]["packages"])
+	assert.Equal(t, 2, discovered[0]["dirs"])
+
+	// Patterns in lazy projects skip the same directories.
+	proj, err = Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+		Lazy:        true,
+	})
+	require.NoError(t, err)
+
+	pattern, err := label.ParsePattern("//...")
+	require.NoError(t, err)
+	err = proj.Run(t.Context(), []*label.Label{pattern}, nil)
+	require.NoError(t, err)
+}
+
+func TestReloadChanged(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/reload", temp)
+	require.NoError(t, err)
+
+	events := &testEvents{}
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		Events:      events,
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	// reload writes the given files, reloads the project, and returns the labels of the modules
+	// that were loaded.
+	reload := func(files map[string]string) []string {
+		changed := map[string]struct{}{}
+		for name, contents := range files {
+			path := filepath.Join(temp, filepath.FromSlash(name))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+			require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+			changed[path] = struct{}{}
+		}
+
+		start := len(events.events)
+		require.NoError(t, proj.reloadChanged(t.Context(), changed))
+
+		var loaded []string
+		for _, e := range events.events[start:] {
+			if e["kind"] == "ModuleLoading" {
+				loaded = append(loaded, e["label"].(*label.Label).String())
+			}
+		}
+		slices.Sort(loaded)
+		return loaded
+	}
+
+	targets := func() []string {
+		var targets []string
+		for _, t := range proj.Targets() {
+			targets = append(targets, t.Label().String())
+		}
+		return targets
+	}
+
+	aLabel, err := label.Parse("//a:a")
+	require.NoError(t, err)
+	a, err := proj.Target(aLabel)
+	require.NoError(t, err)
+
+	// Editing a package's BUILD.dawn only reloads that package.
+	loaded := reload(map[string]string{"b/BUILD.dawn": "@target(sources=glob([\"src/*.txt\"]))\ndef b():\n    pass\n\n@target()\ndef c():\n    pass\n"})
+	assert.Equal(t, []string{"module://b:BUILD.dawn"}, loaded)
+	assert.Equal(t, []string{"//:build", "//:default", "//a:a", "//b:b", "//b:c"}, targets())
+
+	a2, err := proj.Target(aLabel)
+	require.NoError(t, err)
+	assert.Same(t, a, a2)
+
+	// Adding a file that matches a glob reloads the module that called glob.
+	loaded = reload(map[string]string{"b/src/two.txt": "two\n"})
+	assert.Equal(t, []string{"module://b:BUILD.dawn"}, loaded)
+	assert.Equal(t, []string{filepath.Join(temp, "b", "src", "one.txt"), filepath.Join(temp, "b", "src", "two.txt")}, proj.Sources())
+
+	// Editing a loaded module reloads the modules that load it.
+	loaded = reload(map[string]string{"lib/defs.dawn": "greeting = \"goodbye\"\n"})
+	assert.Equal(t, []string{"module://a:BUILD.dawn", "module://lib:defs.dawn"}, loaded)
+
+	a, err = proj.Target(aLabel)
+	require.NoError(t, err)
+	assert.Equal(t, "goodbye", a.Doc())
+
+	// Adding a package reloads the entire project.
+	loaded = reload(map[string]string{"d/BUILD.dawn": "@target()\ndef d():\n    pass\n"})
+	assert.Equal(t, []string{"module://:BUILD.dawn", "module://a:BUILD.dawn", "module://b:BUILD.dawn", "module://d:BUILD.dawn", "module://lib:defs.dawn"}, loaded)
+}
+
+func TestWatchPoll(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/reload", temp)
+	require.NoError(t, err)
+
+	events := &testEvents{}
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		Events:      events,
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	def, err := label.Parse("//:default")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	watchErr := make(chan error)
+	go func() {
+		watchErr <- proj.WatchWithOptions(ctx, []*label.Label{def}, &WatchOptions{Poll: true, PollInterval: 10 * time.Millisecond})
+	}()
+
+	// kinds returns the kinds of the events that have been recorded.
+	kinds := func() []string {
+		events.m.Lock()
+		defer events.m.Unlock()
+
+		var kinds []string
+		for _, e := range events.events {
+			kinds = append(kinds, e["kind"].(string))
+		}
+		return kinds
+	}
+
+	assert.Eventually(t, func() bool { return slices.Contains(kinds(), "WatchPolling") }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// Changing a module is detected by polling and triggers a build.
+	err = os.WriteFile(filepath.Join(temp, "b", "BUILD.dawn"), []byte("@target()\ndef b():\n    pass\n"), 0o600)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return slices.Contains(kinds(), "RunDone") }, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, kinds(), "FileChanged")
+
+	cancel()
+	assert.ErrorIs(t, <-watchErr, context.Canceled)
+}
+
+func TestServices(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/services", temp)
+	require.NoError(t, err)
+
+	events := &testEvents{}
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		Events:      events,
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	server, err := label.Parse("//:server")
+	require.NoError(t, err)
+	oneshot, err := label.Parse("//:oneshot")
+	require.NoError(t, err)
+
+	// starts returns the lines written by each start of the server.
+	starts := func() string {
+		contents, _ := os.ReadFile(filepath.Join(temp, "starts.txt"))
+		return string(contents)
+	}
+
+	// While watching, running a service starts it in the background.
+	proj.watching = true
+	require.NoError(t, proj.Run(t.Context(), []*label.Label{server}, nil))
+	assert.True(t, proj.services.running(server.String()))
+	assert.Eventually(t, func() bool { return starts() == "started 1\n" }, 5*time.Second, 10*time.Millisecond)
+
+	// A running service whose dependencies are unchanged is not restarted.
+	require.NoError(t, proj.Run(t.Context(), []*label.Label{server}, nil))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "started 1\n", starts())
+
+	// A service is restarted after its dependencies are rebuilt.
+	err = os.WriteFile(filepath.Join(temp, "input.txt"), []byte("2\n"), 0o600)
+	require.NoError(t, err)
+	proj.invalidate(proj.snapshot(), map[string]struct{}{filepath.Join(temp, "input.txt"): {}})
+	require.NoError(t, proj.Run(t.Context(), []*label.Label{server}, nil))
+	assert.Eventually(t, func() bool { return starts() == "started 1\nstarted 2\n" }, 5*time.Second, 10*time.Millisecond)
+
+	proj.services.stopAll()
+	assert.False(t, proj.services.running(server.String()))
+
+	// Outside of watch mode, Run waits for its services to exit.
+	proj.watching = false
+	require.NoError(t, proj.Run(t.Context(), []*label.Label{oneshot}, nil))
+	assert.False(t, proj.services.running(oneshot.String()))
+
+	events.m.Lock()
+	defer events.m.Unlock()
+	var lines []string
+	for _, e := range events.events {
+		if l, ok := e["label"].(*label.Label); ok && e["kind"] == "Print" && l.String() == oneshot.String() {
+			lines = append(lines, e["line"].(string))
+		}
+	}
+	assert.Equal(t, []string{"echo done", "done", "service exited"}, lines)
+}
+
+func TestSandbox(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" || exec.Command("unshare", "--user", "--map-root-user", "true").Run() != nil {
+		t.Skip("sandboxing requires Linux user namespaces")
+	}
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/sandbox", temp)
+	require.NoError(t, err)
+
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"os": starlark_os.Module, "sh": starlark_sh.Module},
+		Events:      &testEvents{},
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	run := func(name string) error {
+		l, err := label.Parse("//:" + name)
+		require.NoError(t, err)
+		return proj.Run(t.Context(), []*label.Label{l}, nil)
+	}
+
+	// Declared sources are readable and declared outputs are moved into the project.
+	require.NoError(t, run("declared"))
+	assert.Equal(t, "input\n", string(readFile(t, filepath.Join(temp, "out.txt"))))
+
+	// The files generated by dependencies are readable.
+	require.NoError(t, run("dependency"))
+	assert.Equal(t, "input\n", string(readFile(t, filepath.Join(temp, "copy.txt"))))
+
+	// Undeclared files are not visible.
+	err = run("undeclared_read")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//:undeclared_read is sandboxed")
+	assert.Empty(t, readFile(t, filepath.Join(temp, "read.txt")))
+
+	// Undeclared writes are discarded and reported.
+	err = run("undeclared_write")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//:undeclared_write wrote undeclared files: stray.txt")
+	assert.NoFileExists(t, filepath.Join(temp, "stray.txt"))
+
+	// Staging directories are removed.
+	entries, err := os.ReadDir(proj.temp)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, strings.HasPrefix(e.Name(), "sandbox-"), e.Name())
+	}
+}
+
+func TestCheckHermeticity(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" || exec.Command("unshare", "--user", "--map-root-user", "true").Run() != nil {
+		t.Skip("checking hermeticity requires Linux user namespaces")
+	}
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/hermeticity", temp)
+	require.NoError(t, err)
+
+	// Files in node_modules and files ignored by .gitignore are not staged for audited processes,
+	// so reading them is not reported.
+	require.NoError(t, os.MkdirAll(filepath.Join(temp, "node_modules", "pkg"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(temp, "node_modules", "pkg", "index.js"), []byte("module\n"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(temp, "cache"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(temp, "cache", "data.txt"), []byte("data\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(temp, ".gitignore"), []byte("cache/\n"), 0o600))
+
+	proj, err := Load(t.Context(), temp, &LoadOptions{
+		Builtins:    starlark.StringDict{"os": starlark_os.Module, "sh": starlark_sh.Module},
+		Events:      &testEvents{},
+		ActionCache: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	pattern, err := label.Parse("//...")
+	require.NoError(t, err)
+
+	check := func() {
+		reports, err := proj.CheckHermeticity(t.Context(), []*label.Label{pattern}, nil)
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, "//:leaky", reports[0].Target.Label().String())
+		assert.Equal(t, filepath.Join(temp, "BUILD.dawn")+":10:5", reports[0].Target.Pos())
+		assert.Equal(t, []string{"config.txt"}, reports[0].Reads)
+		assert.Equal(t, []string{"build.log"}, reports[0].Writes)
+	}
+
+	// Audited outputs are visible to the audited processes of dependents, but are not written to
+	// the project.
+	check()
+	assert.NoFileExists(t, filepath.Join(temp, "out.txt"))
+	assert.NoFileExists(t, filepath.Join(temp, "copy.txt"))
+	assert.NoFileExists(t, filepath.Join(temp, "leaky.txt"))
+	assert.NoFileExists(t, filepath.Join(temp, "build.log"))
+
+	// Checking hermeticity does not disturb the outputs of a build.
+	require.NoError(t, proj.Run(t.Context(), []*label.Label{pattern}, nil))
+	assert.Equal(t, "input\nconfig\n", string(readFile(t, filepath.Join(temp, "leaky.txt"))))
+	assert.Equal(t, "module\ndata\n", string(readFile(t, filepath.Join(temp, "probe.txt"))))
+
+	check()
+	assert.Equal(t, "input\n", string(readFile(t, filepath.Join(temp, "copy.txt"))))
+	assert.Equal(t, "input\nconfig\n", string(readFile(t, filepath.Join(temp, "leaky.txt"))))
+
+	// Processes are not audited once the check is complete.
+	assert.Nil(t, proj.audit("//:leaky"))
+
+	// Audited results are not cached, so rebuilding a removed output produces its real contents.
+	require.NoError(t, os.Remove(filepath.Join(temp, "leaky.txt")))
+	require.NoError(t, proj.Run(t.Context(), []*label.Label{pattern}, nil))
+	assert.Equal(t, "input\nconfig\n", string(readFile(t, filepath.Join(temp, "leaky.txt"))))
+}
+
+func TestExternalInputs(t *testing.T) {
+	bin := t.TempDir()
+	tool := filepath.Join(bin, "dawn-test-tool")
+	require.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\n"), 0o700)) //nolint:gosec
+	t.Setenv("PATH", bin+string(filepath.ListSeparator)+os.Getenv("PATH"))
+	t.Setenv("DAWN_TEST_VALUE", "1")
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/external-inputs", temp)
+	require.NoError(t, err)
+
+	check, err := label.Parse("//:check")
+	require.NoError(t, err)
+
+	options := LoadOptions{ActionCache: t.TempDir()}
+	assert.Equal(t, "target has never been run", buildTarget(t, temp, options, check))
+	assert.Equal(t, "", buildTarget(t, temp, options, check))
+
+	t.Setenv("DAWN_TEST_VALUE", "2")
+	assert.Equal(t, "environment variable DAWN_TEST_VALUE changed", buildTarget(t, temp, options, check))
+
+	require.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\nexit 0\n"), 0o700)) //nolint:gosec
+	assert.Equal(t, "tool dawn-test-tool changed", buildTarget(t, temp, options, check))
+
+	require.NoError(t, os.Unsetenv("DAWN_TEST_VALUE"))
+	require.NoError(t, os.Remove(tool))
+	assert.Equal(t, "environment variable DAWN_TEST_VALUE and tool dawn-test-tool changed", buildTarget(t, temp, options, check))
+	assert.Equal(t, "", buildTarget(t, temp, options, check))
+}
+
+func TestDepfile(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/depfile", temp)
+	require.NoError(t, err)
+
+	compile, err := label.Parse("//:compile")
+	require.NoError(t, err)
+
+	header := filepath.Join(temp, "include", "util.h")
+	options := LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	}
+
+	assert.Equal(t, "target has never been run", buildTarget(t, temp, options, compile))
+	assert.Equal(t, "", buildTarget(t, temp, options, compile))
+
+	// Changing a header listed in the depfile re-runs the target.
+	require.NoError(t, os.WriteFile(header, []byte("int util(int);\n"), 0o600))
+	assert.Equal(t, "discovered source include/util.h changed", buildTarget(t, temp, options, compile))
+	assert.Equal(t, "#include \"include/util.h\"\nint util(int);\n", string(readFile(t, filepath.Join(temp, "main.o"))))
+	assert.Equal(t, "", buildTarget(t, temp, options, compile))
+
+	// The cached result for the target's action key lists the changed header, so it must not be
+	// restored once the header is reverted.
+	require.NoError(t, os.WriteFile(header, []byte("int util(void);\n"), 0o600))
+	assert.Equal(t, "discovered source include/util.h changed", buildTarget(t, temp, options, compile))
+	assert.Equal(t, "#include \"include/util.h\"\nint util(void);\n", string(readFile(t, filepath.Join(temp, "main.o"))))
+
+	// In watch mode, changes to discovered sources invalidate the target.
+	proj, err := Load(t.Context(), temp, &options)
+	require.NoError(t, err)
+	affected := proj.affectedTargets(proj.snapshot(), map[string]struct{}{header: {}})
+	assert.Equal(t, []string{compile.String()}, affected)
+}
+
+func TestRequire(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/require", temp)
+	require.NoError(t, err)
+
+	bundle, err := label.Parse("//:bundle")
+	require.NoError(t, err)
+
+	options := LoadOptions{
+		Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+		ActionCache: t.TempDir(),
+	}
+	write := func(path, contents string) {
+		require.NoError(t, os.WriteFile(filepath.Join(temp, path), []byte(contents), 0o600))
+	}
+	output := func() string {
+		return string(readFile(t, filepath.Join(temp, "bundle.txt")))
+	}
+
+	assert.Equal(t, "target has never been run", buildTarget(t, temp, options, bundle))
+	assert.Equal(t, "v1\n", output())
+	assert.Equal(t, "", buildTarget(t, temp, options, bundle))
+
+	// Changing a required target re-runs the bundle.
+	write("lib.in", "v2\n")
+	assert.Equal(t, "out-of-date dependencies: //:lib", buildTarget(t, temp, options, bundle))
+	assert.Equal(t, "v2\n", output())
+	assert.Equal(t, "", buildTarget(t, temp, options, bundle))
+
+	// The cached result for the bundle's action key required the changed target, so it must not
+	// be restored once the target is reverted.
+	write("lib.in", "v1\n")
+	assert.Equal(t, "out-of-date dependencies: //:lib", buildTarget(t, temp, options, bundle))
+	assert.Equal(t, "v1\n", output())
+
+	// Requiring a target that does not exist fails the bundle.
+	write("imports.txt", ":missing\n")
+	proj, err := Load(t.Context(), temp, &options)
+	require.NoError(t, err)
+	err = proj.Run(t.Context(), []*label.Label{bundle}, nil)
+	assert.ErrorContains(t, err, "require: unknown target //:missing")
+
+	// Once the bundle no longer requires a target, changes to that target do not affect it.
+	write("imports.txt", "\n")
+	assert.Equal(t, "out-of-date dependencies: source://:imports.txt", buildTarget(t, temp, options, bundle))
+	assert.Equal(t, "", output())
+	write("lib.in", "v3\n")
+	assert.Equal(t, "", buildTarget(t, temp, options, bundle))
+}
+
+func TestIndexStaleness(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	err := copy.Copy("testdata/index-staleness", temp)
+	require.NoError(t, err)
+
+	build, err := label.Parse("//:build")
+	require.NoError(t, err)
+
+	// indexed loads the project with the given arguments and returns true if the project was
+	// loaded from its index.
+	indexed := func(args ...string) bool {
+		proj, err := Load(t.Context(), temp, &LoadOptions{
+			Args:        args,
+			Builtins:    starlark.StringDict{"sh": starlark_sh.Module},
+			PreferIndex: true,
+			ActionCache: t.TempDir(),
+		})
+		require.NoError(t, err)
+
+		target, err := proj.Target(build)
+		require.NoError(t, err)
+		_, ok := target.(*indexTarget)
+		return ok
+	}
+	write := func(path, contents string) {
+		path = filepath.Join(temp, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	}
+
+	assert.False(t, indexed())
+	assert.True(t, indexed())
+
+	// Editing a package's module invalidates the index.
+	write("BUILD.dawn", string(readFile(t, filepath.Join(temp, "BUILD.dawn")))+"# edited\n")
+	assert.False(t, indexed())
+	assert.True(t, indexed())
+
+	// Editing a loaded module invalidates the index.
+	write("lib/defs.dawn", string(readFile(t, filepath.Join(temp, "lib", "defs.dawn")))+"# edited\n")
+	assert.False(t, indexed())
+	assert.True(t, indexed())
+
+	// Adding a file that matches a glob invalidates the index.
+	write("src/b.txt", "b\n")
+	assert.False(t, indexed())
+	assert.True(t, indexed())
+
+	// Changing a flag's value invalidates the index.
+	assert.False(t, indexed("--mode=debug"))
+	assert.True(t, indexed("--mode=debug"))
+	assert.False(t, indexed())
+	assert.True(t, indexed())
+
+	// Adding a package invalidates the index.
+	write("pkg/BUILD.dawn", "")
+	assert.False(t, indexed())
+	assert.True(t, indexed())
+}
+
+func TestOutputDrift(t *testing.T) {
+	t.Parallel()
+	pt := projectTest{
+		path:  "testdata/output-drift",
+		edits: []string{"edit1"},
+		validate: func(t *testing.T, dir string, events []testEvent) {
+			expected := readFile(t, filepath.Join(dir, "expected.txt"))
+			actual := readFile(t, filepath.Join(dir, "out.txt"))
+			assert.Equal(t, expected, actual)
+
+			var reason any
+			for _, e := range slices.Backward(events) {
+				if e["kind"].(string) == "TargetEvaluating" {
+					reason = e["reason"]
+					break
+				}
+			}
+			expectedReason := strings.TrimSpace(string(readFile(t, filepath.Join(dir, "reason.txt"))))
+			assert.Equal(t, expectedReason, reason)
+		},
+	}
+	pt.run(t)
+}
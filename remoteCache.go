@@ -0,0 +1,105 @@
+package dawn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pgavlin/dawn/internal/project"
+)
+
+// remoteCacheTimeout bounds the time spent on each request to a remote cache, including the time
+// spent reading the response body. Requests that time out fail, so a slow or unresponsive remote
+// cache cannot stall a build: failed reads are treated as cache misses, and failed writes are
+// ignored.
+const remoteCacheTimeout = 30 * time.Second
+
+// A remoteCache is an HTTP action cache. Action results are stored at <url>/ac/<action key>,
+// and the contents of generated files are stored at <url>/cas/<SHA-256 sum>. Entries are
+// fetched using GET requests and stored using PUT requests.
+type remoteCache struct {
+	url      string
+	client   *http.Client
+	writable bool
+}
+
+// newRemoteCache returns a remote cache for the given URL and mode. If the URL is empty, the
+// returned cache is nil.
+func newRemoteCache(url, mode string) (*remoteCache, error) {
+	if url == "" {
+		return nil, nil
+	}
+	if err := project.ValidateCacheMode(mode); err != nil {
+		return nil, err
+	}
+	return &remoteCache{
+		url:      strings.TrimSuffix(url, "/"),
+		client:   &http.Client{Timeout: remoteCacheTimeout},
+		writable: mode == project.CacheWriteThrough,
+	}, nil
+}
+
+// get fetches the entry with the given kind and name and writes its contents to w. If the entry
+// does not exist, get returns false.
+func (r *remoteCache) get(ctx context.Context, kind, name string, w io.Writer) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url+"/"+kind+"/"+name, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		_, err = io.Copy(w, resp.Body)
+		return err == nil, err
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("GET %v/%v: %v", kind, name, resp.Status)
+	}
+}
+
+// put stores the given contents as the entry with the given kind and name.
+func (r *remoteCache) put(ctx context.Context, kind, name string, contents io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, r.url+"/"+kind+"/"+name, contents)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %v/%v: %v", kind, name, resp.Status)
+	}
+	return nil
+}
+
+// putFile stores the contents of the file at the given path as the entry with the given kind and
+// name.
+func (r *remoteCache) putFile(ctx context.Context, kind, name, path string) error {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return r.put(ctx, kind, name, f, stat.Size())
+}
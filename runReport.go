@@ -0,0 +1,114 @@
+package dawn
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// A TargetFailure describes a target that failed during a run.
+type TargetFailure struct {
+	// Label is the label of the failed target.
+	Label string
+	// Err is the error that caused the target to fail.
+	Err error
+	// Blocked holds the labels of the targets that did not run because the target failed.
+	Blocked []string
+}
+
+// A RunError is returned by Project.Run if targets fail during a run that keeps going. It
+// describes each failed target and the dependents that its failure blocked.
+type RunError struct {
+	Failures []TargetFailure
+}
+
+func (e *RunError) Error() string {
+	var b strings.Builder
+	if len(e.Failures) == 1 {
+		b.WriteString("1 target failed:")
+	} else {
+		fmt.Fprintf(&b, "%v targets failed:", len(e.Failures))
+	}
+	for _, f := range e.Failures {
+		fmt.Fprintf(&b, "\n  %v: %v", f.Label, f.Err)
+		if len(f.Blocked) != 0 {
+			fmt.Fprintf(&b, "\n    blocked: %v", strings.Join(f.Blocked, ", "))
+		}
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors that caused each target to fail.
+func (e *RunError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// A runReport records the targets that fail during a run and the targets that are blocked by
+// those failures.
+type runReport struct {
+	m        sync.Mutex
+	failures map[string]error
+	blocked  map[string][]string // maps each blocked target to its failed or blocked dependencies
+}
+
+func newRunReport() *runReport {
+	return &runReport{failures: map[string]error{}, blocked: map[string][]string{}}
+}
+
+func (r *runReport) targetFailed(label string, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.failures[label] = err
+}
+
+func (r *runReport) targetBlocked(label string, deps []string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.blocked[label] = deps
+}
+
+// err returns a *RunError that describes the run's failures, or nil if no targets failed.
+func (r *runReport) err() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if len(r.failures) == 0 {
+		return nil
+	}
+
+	dependents := map[string][]string{}
+	for label, deps := range r.blocked {
+		for _, dep := range deps {
+			dependents[dep] = append(dependents[dep], label)
+		}
+	}
+
+	failures := make([]TargetFailure, 0, len(r.failures))
+	for _, label := range slices.Sorted(maps.Keys(r.failures)) {
+		blocked := map[string]struct{}{}
+		queue := dependents[label]
+		for len(queue) != 0 {
+			dependent := queue[0]
+			queue = queue[1:]
+			if _, ok := blocked[dependent]; !ok {
+				blocked[dependent] = struct{}{}
+				queue = append(queue, dependents[dependent]...)
+			}
+		}
+
+		failures = append(failures, TargetFailure{
+			Label:   label,
+			Err:     r.failures[label],
+			Blocked: slices.Sorted(maps.Keys(blocked)),
+		})
+	}
+	return &RunError{Failures: failures}
+}
@@ -1,13 +1,17 @@
 package runner
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pgavlin/fx/v2"
 )
@@ -30,6 +34,10 @@ func (e *CyclicDependencyError) Trace() string {
 	return out.String()
 }
 
+// ErrStopped is returned for targets that were not evaluated because another target failed
+// during a run that does not keep going.
+var ErrStopped = errors.New("build stopped after a failure")
+
 type Result struct {
 	Target Target
 	Error  error
@@ -39,6 +47,13 @@ type Targets interface {
 	LoadTarget(ctx context.Context, label string) (Target, error)
 }
 
+// A DurationEstimator estimates the time required to evaluate a target. If the Targets passed to
+// NewRunner implement DurationEstimator, the runner uses its estimates to prioritize targets that
+// lie on the longest remaining critical path.
+type DurationEstimator interface {
+	EstimateDuration(label string) time.Duration
+}
+
 type Engine interface {
 	EvaluateTargets(ctx context.Context, labels ...string) []Result
 }
@@ -47,6 +62,16 @@ type Target interface {
 	Evaluate(ctx context.Context, engine Engine) error
 }
 
+// A ResourceTarget is a Target that requires resources from the runner's resource pools while
+// it is evaluated. Resources maps the name of each pool to the number of units the target
+// requires from that pool. Every target requires one unit of the default pool unless its
+// resources say otherwise.
+type ResourceTarget interface {
+	Target
+
+	Resources() map[string]int
+}
+
 const (
 	statusIdle = iota
 	statusRunning
@@ -59,13 +84,16 @@ type target struct {
 	m sync.Mutex
 	c *sync.Cond
 
-	label  string
-	target Target
+	label     string
+	target    Target
+	resources map[string]int
 
-	waiting atomic.Pointer[[]*target]
+	waiting  atomic.Pointer[[]*target]
+	priority atomic.Int64 // the estimated duration of the longest path from the target to a root
 
-	status int
-	err    error
+	status     int
+	err        error
+	dependents map[*target]struct{} // the targets that depend on this target
 }
 
 func newTarget(label string) *target {
@@ -87,6 +115,62 @@ func (t *target) start(ctx context.Context, r *Runner) {
 	go t.run(ctx, r)
 }
 
+// addDependent records that the given target depends on the receiver.
+func (t *target) addDependent(dependent *target) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.dependents == nil {
+		t.dependents = map[*target]struct{}{}
+	}
+	t.dependents[dependent] = struct{}{}
+}
+
+// reset returns the target to its initial state and returns the targets that depended on it.
+// The dependents are forgotten: if they still depend on the target, they will record that
+// dependency again when they are re-evaluated.
+func (t *target) reset() []*target {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	dependents := slices.Collect(maps.Keys(t.dependents))
+	t.status, t.err, t.target, t.dependents = statusIdle, nil, nil, nil
+	t.priority.Store(0)
+	return dependents
+}
+
+// refresh prepares a target that was evaluated by a previous run for the next run. Failed
+// targets are reset so that they are re-evaluated. Succeeded targets are reloaded so that their
+// dependents observe the current version of the target. If a succeeded target can no longer be
+// loaded, it is reset.
+func (t *target) refresh(ctx context.Context, r *Runner) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	switch t.status {
+	case statusFailed:
+		t.status, t.err, t.target = statusIdle, nil, nil
+	case statusSucceeded:
+		tt, err := r.targetLoader.LoadTarget(ctx, t.label)
+		if err != nil {
+			t.status, t.target = statusIdle, nil
+		} else {
+			t.target = tt
+		}
+	}
+	t.priority.Store(0)
+}
+
+// prioritize raises the target's priority to at least the given value.
+func (t *target) prioritize(priority time.Duration) {
+	for {
+		old := t.priority.Load()
+		if int64(priority) <= old || t.priority.CompareAndSwap(old, int64(priority)) {
+			return
+		}
+	}
+}
+
 func (t *target) wait() error {
 	t.m.Lock()
 	defer t.m.Unlock()
@@ -106,8 +190,10 @@ func (t *target) run(ctx context.Context, r *Runner) {
 		t.c.Broadcast()
 	}
 
-	r.gate.enter()
-	defer r.gate.exit()
+	// Targets are loaded using the default resources.
+	t.resources = r.pools.defaultRequest()
+	r.pools.enter(t, t.resources)
+	defer func() { r.pools.exit(t.resources) }()
 
 	// Load the target.
 	tt, err := r.targetLoader.LoadTarget(ctx, t.label)
@@ -120,10 +206,35 @@ func (t *target) run(ctx context.Context, r *Runner) {
 	}
 	t.target = tt
 
+	// Exchange the default resources for those required by the target.
+	resources, err := r.pools.request(tt)
+	if err != nil {
+		t.m.Lock()
+		defer unlock()
+
+		t.status, t.err = statusFailed, err
+		return
+	}
+	if !maps.Equal(resources, t.resources) {
+		r.pools.exit(t.resources)
+		t.resources = resources
+		r.pools.enter(t, t.resources)
+	}
+
+	// Unless the run is keeping going, don't start any new targets once a target has failed.
+	if !r.keepGoing && r.failed.Load() {
+		t.m.Lock()
+		defer unlock()
+
+		t.status, t.err = statusFailed, ErrStopped
+		return
+	}
+
 	// Evaluate and save the target.
 	status := statusSucceeded
 	if err = t.target.Evaluate(ctx, &engine{root: t, runner: r}); err != nil {
 		status = statusFailed
+		r.failed.Store(true)
 	}
 
 	t.m.Lock()
@@ -164,12 +275,16 @@ func (e *engine) checkDeps(set fx.Set[*target], path []string, deps []*target) e
 }
 
 func (e *engine) EvaluateTargets(ctx context.Context, labels ...string) []Result {
-	e.runner.gate.exit()
-	defer e.runner.gate.enter()
+	// Release the target's resources while it waits on its dependencies.
+	e.runner.pools.exit(e.root.resources)
+	defer e.runner.pools.enter(e.root, e.root.resources)
 
+	priority := time.Duration(e.root.priority.Load())
 	targets := make([]*target, len(labels))
 	for i, label := range labels {
 		targets[i] = e.runner.getTarget(label)
+		targets[i].addDependent(e.root)
+		targets[i].prioritize(priority + e.runner.estimate(label))
 		targets[i].start(ctx, e.runner)
 	}
 
@@ -191,60 +306,226 @@ func (e *engine) EvaluateTargets(ctx context.Context, labels ...string) []Result
 	return results
 }
 
-type gate struct {
-	m        sync.Mutex
-	cond     *sync.Cond
-	capacity int
-	running  int
-	waiting  int
+// DefaultPool is the name of the default resource pool. The capacity of the default pool limits
+// the number of targets that are evaluated concurrently.
+const DefaultPool = "cpu"
+
+// Metrics describes the state of a runner.
+type Metrics struct {
+	// Running is the number of targets that hold their resources.
+	Running int
+	// Waiting is the number of targets that are waiting for resources.
+	Waiting int
+	// Pools describes the usage of each resource pool, sorted by name.
+	Pools []PoolMetrics
 }
 
-func newGate(capacity int) *gate {
-	g := &gate{capacity: capacity}
-	g.cond = sync.NewCond(&g.m)
-	return g
+// PoolMetrics describes the usage of a resource pool.
+type PoolMetrics struct {
+	Name     string
+	Capacity int
+	InUse    int
 }
 
-func (g *gate) enter() {
-	g.m.Lock()
-	defer g.m.Unlock()
+// Scheduling policies.
+const (
+	// ScheduleCriticalPath admits waiting targets in order of the estimated duration of the
+	// longest path from each target to the targets being run, longest first. Targets with equal
+	// estimates are admitted in the order in which they began waiting.
+	ScheduleCriticalPath = "critical-path"
+	// ScheduleFIFO admits waiting targets in the order in which they began waiting.
+	ScheduleFIFO = "fifo"
+)
+
+// pools tracks the usage of a runner's resource pools. A target is only admitted once all of
+// the resources it requires are available. When resources are released, waiting targets are
+// admitted in the order determined by the scheduling policy.
+type pools struct {
+	m            sync.Mutex
+	cond         *sync.Cond
+	capacity     map[string]int
+	available    map[string]int
+	criticalPath bool
+	running      int
+	waiters      []*waiter
+	seq          uint64
+}
+
+// A waiter is a target that is waiting for resources.
+type waiter struct {
+	target   *target
+	request  map[string]int
+	seq      uint64
+	priority int64
+	admitted bool
+}
+
+func newPools(capacity map[string]int) *pools {
+	p := &pools{capacity: capacity, available: maps.Clone(capacity)}
+	p.cond = sync.NewCond(&p.m)
+	return p
+}
+
+func (p *pools) defaultRequest() map[string]int {
+	return map[string]int{DefaultPool: 1}
+}
 
-	g.waiting++
-	for g.capacity == 0 {
-		g.cond.Wait()
+// request returns the resources required by the given target. Requests that exceed the
+// capacity of a pool are limited to its capacity so that the target can run once it has
+// exclusive use of the pool.
+func (p *pools) request(t Target) (map[string]int, error) {
+	rt, ok := t.(ResourceTarget)
+	if !ok {
+		return p.defaultRequest(), nil
 	}
-	g.waiting--
-	g.capacity--
-	g.running++
+	resources := rt.Resources()
+	if len(resources) == 0 {
+		return p.defaultRequest(), nil
+	}
+
+	request := p.defaultRequest()
+	for name, amount := range resources {
+		capacity, ok := p.capacity[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("unknown resource pool %q", name)
+		case amount < 0:
+			return nil, fmt.Errorf("invalid amount %v for resource pool %q", amount, name)
+		}
+		request[name] = min(amount, capacity)
+	}
+	return request, nil
+}
+
+func (p *pools) fits(request map[string]int) bool {
+	for name, amount := range request {
+		if p.available[name] < amount {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *pools) setSchedule(schedule string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.criticalPath = schedule == ScheduleCriticalPath
 }
 
-func (g *gate) exit() {
-	g.m.Lock()
-	defer g.m.Unlock()
+func (p *pools) enter(t *target, request map[string]int) {
+	p.m.Lock()
+	defer p.m.Unlock()
 
-	g.running--
-	g.capacity++
-	g.cond.Signal()
+	w := &waiter{target: t, request: request, seq: p.seq}
+	p.seq++
+
+	p.waiters = append(p.waiters, w)
+	p.admit()
+	for !w.admitted {
+		p.cond.Wait()
+	}
+}
+
+func (p *pools) exit(request map[string]int) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.running--
+	for name, amount := range request {
+		p.available[name] += amount
+	}
+	p.admit()
+}
+
+// admit admits as many waiters as possible in scheduling order. Waiters whose requests do not
+// fit are skipped so that smaller requests may use the remaining resources. The caller must hold
+// p.m.
+func (p *pools) admit() {
+	// Snapshot each waiter's priority, which may be raised concurrently.
+	for _, w := range p.waiters {
+		w.priority = w.target.priority.Load()
+	}
+	slices.SortFunc(p.waiters, func(a, b *waiter) int {
+		if p.criticalPath {
+			if c := cmp.Compare(b.priority, a.priority); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	admitted, waiting := false, p.waiters[:0]
+	for _, w := range p.waiters {
+		if !p.fits(w.request) {
+			waiting = append(waiting, w)
+			continue
+		}
+
+		for name, amount := range w.request {
+			p.available[name] -= amount
+		}
+		p.running++
+		w.admitted, admitted = true, true
+	}
+	clear(p.waiters[len(waiting):])
+	p.waiters = waiting
+
+	if admitted {
+		p.cond.Broadcast()
+	}
 }
 
-func (g *gate) metrics() (running, waiting int) {
-	g.m.Lock()
-	defer g.m.Unlock()
+func (p *pools) metrics() Metrics {
+	p.m.Lock()
+	defer p.m.Unlock()
 
-	return g.running, g.waiting
+	pools := make([]PoolMetrics, 0, len(p.capacity))
+	for _, name := range slices.Sorted(maps.Keys(p.capacity)) {
+		capacity := p.capacity[name]
+		pools = append(pools, PoolMetrics{Name: name, Capacity: capacity, InUse: capacity - p.available[name]})
+	}
+	return Metrics{Running: p.running, Waiting: len(p.waiters), Pools: pools}
+}
+
+// Options control the behavior of a run.
+type Options struct {
+	// KeepGoing causes the runner to continue evaluating every target that does not depend on
+	// a failed target. By default, no new targets are started once a target fails.
+	KeepGoing bool
+	// Schedule is the policy used to admit targets that are waiting for resources, either
+	// ScheduleCriticalPath or ScheduleFIFO. Defaults to ScheduleCriticalPath.
+	Schedule string
 }
 
 type Runner struct {
 	targetLoader Targets
 	targetMap    sync.Map // map[string]*target
-	gate         *gate
+	pools        *pools
+
+	keepGoing bool
+	failed    atomic.Bool
+}
+
+// NewRunner creates a new runner for the given targets. The pools map holds the capacity of each
+// of the runner's resource pools. If the capacity of the default pool is not given, it defaults
+// to the number of CPUs.
+func NewRunner(targets Targets, pools map[string]int) *Runner {
+	capacity := maps.Clone(pools)
+	if capacity == nil {
+		capacity = map[string]int{}
+	}
+	if capacity[DefaultPool] <= 0 {
+		capacity[DefaultPool] = runtime.NumCPU()
+	}
+	return &Runner{targetLoader: targets, pools: newPools(capacity)}
 }
 
-func NewRunner(targets Targets, maxParallelism int) *Runner {
-	if maxParallelism <= 0 {
-		maxParallelism = runtime.NumCPU()
+func (r *Runner) estimate(label string) time.Duration {
+	if estimator, ok := r.targetLoader.(DurationEstimator); ok {
+		return estimator.EstimateDuration(label)
 	}
-	return &Runner{targetLoader: targets, gate: newGate(maxParallelism)}
+	return 0
 }
 
 func (r *Runner) getTarget(label string) *target {
@@ -252,12 +533,69 @@ func (r *Runner) getTarget(label string) *target {
 	return tv.(*target)
 }
 
-func (r *Runner) Run(ctx context.Context, label string) error {
-	t := r.getTarget(label)
-	t.start(ctx, r)
-	return t.wait()
+// Run evaluates the targets with the given labels. The targets are evaluated in a single pass,
+// so targets that are shared by their dependencies are only evaluated once.
+func (r *Runner) Run(ctx context.Context, labels []string, options *Options) error {
+	var opts Options
+	if options != nil {
+		opts = *options
+	}
+
+	switch opts.Schedule {
+	case "":
+		opts.Schedule = ScheduleCriticalPath
+	case ScheduleCriticalPath, ScheduleFIFO:
+		// OK
+	default:
+		return fmt.Errorf("unknown schedule %q (must be %q or %q)", opts.Schedule, ScheduleCriticalPath, ScheduleFIFO)
+	}
+	r.pools.setSchedule(opts.Schedule)
+
+	r.keepGoing = opts.KeepGoing
+	r.failed.Store(false)
+
+	r.targetMap.Range(func(_, tv any) bool {
+		tv.(*target).refresh(ctx, r)
+		return true
+	})
+
+	targets := make([]*target, len(labels))
+	for i, label := range labels {
+		targets[i] = r.getTarget(label)
+		targets[i].prioritize(r.estimate(label))
+		targets[i].start(ctx, r)
+	}
+
+	errs := make([]error, len(targets))
+	for i, t := range targets {
+		errs[i] = t.wait()
+	}
+	return errors.Join(errs...)
+}
+
+// Invalidate resets the targets with the given labels and every target that depends on them,
+// directly or transitively, so that they are re-evaluated by the next run. The results of all
+// other targets are retained. Invalidate must not be called during a run.
+func (r *Runner) Invalidate(labels ...string) {
+	var queue []*target
+	for _, label := range labels {
+		if tv, ok := r.targetMap.Load(label); ok {
+			queue = append(queue, tv.(*target))
+		}
+	}
+
+	reset := map[*target]bool{}
+	for len(queue) != 0 {
+		t := queue[0]
+		queue = queue[1:]
+		if !reset[t] {
+			reset[t] = true
+			queue = append(queue, t.reset()...)
+		}
+	}
 }
 
-func (r *Runner) Metrics() (running, waiting int) {
-	return r.gate.metrics()
+// Metrics returns the current state of the runner's resource pools.
+func (r *Runner) Metrics() Metrics {
+	return r.pools.metrics()
 }
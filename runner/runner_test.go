@@ -2,8 +2,11 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/test-go/testify/require"
 )
@@ -14,8 +17,26 @@ func (t testTarget) Evaluate(_ context.Context, engine Engine) error {
 	return t(engine)
 }
 
+type resourceTarget struct {
+	testTarget
+	resources map[string]int
+}
+
+func (t resourceTarget) Resources() map[string]int {
+	return t.resources
+}
+
 type testTargets map[string]Target
 
+type estimatedTargets struct {
+	testTargets
+	estimates map[string]time.Duration
+}
+
+func (tt estimatedTargets) EstimateDuration(label string) time.Duration {
+	return tt.estimates[label]
+}
+
 func (tt testTargets) LoadTarget(_ context.Context, label string) (Target, error) {
 	if t, ok := tt[label]; ok {
 		return t, nil
@@ -35,7 +56,7 @@ func TestCyclicDependency(t *testing.T) {
 	err := NewRunner(testTargets{
 		"foo": foo,
 		"bar": bar,
-	}, 0).Run(t.Context(), "foo")
+	}, nil).Run(t.Context(), []string{"foo"}, nil)
 	require.Error(t, err)
 }
 
@@ -55,6 +76,240 @@ func TestCyclicDependency_Inner(t *testing.T) {
 		"foo": foo,
 		"bar": bar,
 		"baz": baz,
-	}, 0).Run(t.Context(), "foo")
+	}, nil).Run(t.Context(), []string{"foo"}, nil)
+	require.Error(t, err)
+}
+
+func TestKeepGoing(t *testing.T) {
+	t.Parallel()
+	for _, keepGoing := range []bool{false, true} {
+		ran := false
+		targets := testTargets{
+			"root": testTarget(func(engine Engine) error {
+				errA := engine.EvaluateTargets(t.Context(), "a")[0].Error
+				errB := engine.EvaluateTargets(t.Context(), "b")[0].Error
+				return errors.Join(errA, errB)
+			}),
+			"a": testTarget(func(_ Engine) error {
+				return errors.New("failed")
+			}),
+			"b": testTarget(func(_ Engine) error {
+				ran = true
+				return nil
+			}),
+		}
+
+		err := NewRunner(targets, map[string]int{DefaultPool: 1}).Run(t.Context(), []string{"root"}, &Options{KeepGoing: keepGoing})
+		require.Error(t, err)
+		require.Equal(t, keepGoing, ran)
+		if !keepGoing {
+			require.True(t, errors.Is(err, ErrStopped))
+		}
+	}
+}
+
+func TestRunMultiple(t *testing.T) {
+	t.Parallel()
+
+	var m sync.Mutex
+	runs := map[string]int{}
+	target := func(label string, deps ...string) Target {
+		return testTarget(func(engine Engine) error {
+			m.Lock()
+			runs[label]++
+			m.Unlock()
+
+			for _, r := range engine.EvaluateTargets(t.Context(), deps...) {
+				if r.Error != nil {
+					return r.Error
+				}
+			}
+			return nil
+		})
+	}
+
+	err := NewRunner(testTargets{
+		"a":      target("a", "shared"),
+		"b":      target("b", "shared"),
+		"shared": target("shared"),
+	}, nil).Run(t.Context(), []string{"a", "b"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"a": 1, "b": 1, "shared": 1}, runs)
+}
+
+func TestInvalidate(t *testing.T) {
+	t.Parallel()
+
+	var m sync.Mutex
+	runs := map[string]int{}
+	target := func(label string, deps ...string) Target {
+		return testTarget(func(engine Engine) error {
+			m.Lock()
+			runs[label]++
+			m.Unlock()
+
+			for _, r := range engine.EvaluateTargets(t.Context(), deps...) {
+				if r.Error != nil {
+					return r.Error
+				}
+			}
+			return nil
+		})
+	}
+
+	r := NewRunner(testTargets{
+		"root": target("root", "a", "b"),
+		"a":    target("a", "c"),
+		"b":    target("b"),
+		"c":    target("c"),
+	}, nil)
+	require.NoError(t, r.Run(t.Context(), []string{"root"}, nil))
+	require.Equal(t, map[string]int{"root": 1, "a": 1, "b": 1, "c": 1}, runs)
+
+	// Without invalidation, nothing is re-evaluated.
+	require.NoError(t, r.Run(t.Context(), []string{"root"}, nil))
+	require.Equal(t, map[string]int{"root": 1, "a": 1, "b": 1, "c": 1}, runs)
+
+	// Invalidating a target re-evaluates it and its transitive dependents.
+	r.Invalidate("c")
+	require.NoError(t, r.Run(t.Context(), []string{"root"}, nil))
+	require.Equal(t, map[string]int{"root": 2, "a": 2, "b": 1, "c": 2}, runs)
+
+	r.Invalidate("b")
+	require.NoError(t, r.Run(t.Context(), []string{"root"}, nil))
+	require.Equal(t, map[string]int{"root": 3, "a": 2, "b": 2, "c": 2}, runs)
+}
+
+func TestResources(t *testing.T) {
+	t.Parallel()
+
+	var r *Runner
+	var m sync.Mutex
+	running, maxRunning, maxInUse := 0, 0, 0
+	db := func(_ Engine) error {
+		m.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		maxInUse = max(maxInUse, r.Metrics().Pools[1].InUse)
+		m.Unlock()
+
+		defer func() {
+			m.Lock()
+			running--
+			m.Unlock()
+		}()
+
+		// Give the other targets a chance to run concurrently.
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	r = NewRunner(testTargets{
+		"root": testTarget(func(engine Engine) error {
+			return errors.Join(resultErrors(engine.EvaluateTargets(t.Context(), "a", "b", "c"))...)
+		}),
+		"a": resourceTarget{testTarget(db), map[string]int{"db": 1}},
+		"b": resourceTarget{testTarget(db), map[string]int{"db": 1}},
+		"c": resourceTarget{testTarget(db), map[string]int{"db": 2}},
+	}, map[string]int{DefaultPool: 4, "db": 1})
+	err := r.Run(t.Context(), []string{"root"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, maxRunning)
+	require.Equal(t, 1, maxInUse)
+
+	metrics := r.Metrics()
+	require.Equal(t, 0, metrics.Running)
+	require.Equal(t, 0, metrics.Waiting)
+	require.Equal(t, []PoolMetrics{
+		{Name: DefaultPool, Capacity: 4},
+		{Name: "db", Capacity: 1},
+	}, metrics.Pools)
+
+	// Targets may not request resources from unknown pools.
+	err = NewRunner(testTargets{
+		"a": resourceTarget{testTarget(func(_ Engine) error { return nil }), map[string]int{"gpu": 1}},
+	}, nil).Run(t.Context(), []string{"a"}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unknown resource pool "gpu"`)
+}
+
+func resultErrors(results []Result) []error {
+	errs := make([]error, len(results))
+	for i, r := range results {
+		errs[i] = r.Error
+	}
+	return errs
+}
+
+func TestSchedule(t *testing.T) {
+	t.Parallel()
+
+	for _, schedule := range []string{ScheduleFIFO, ScheduleCriticalPath} {
+		p := newPools(map[string]int{DefaultPool: 1})
+		p.setSchedule(schedule)
+
+		// Occupy the pool so that the remaining targets must wait.
+		busy := newTarget("busy")
+		p.enter(busy, p.defaultRequest())
+
+		var m sync.Mutex
+		var order []string
+		var wg sync.WaitGroup
+		for i, priority := range []time.Duration{time.Second, time.Minute, time.Hour} {
+			tt := newTarget(fmt.Sprint(i))
+			tt.prioritize(priority)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				p.enter(tt, p.defaultRequest())
+				m.Lock()
+				order = append(order, tt.label)
+				m.Unlock()
+				p.exit(p.defaultRequest())
+			}()
+
+			// Wait for the target to begin waiting so that arrival order is deterministic.
+			for p.metrics().Waiting != i+1 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		p.exit(p.defaultRequest())
+		wg.Wait()
+
+		if schedule == ScheduleFIFO {
+			require.Equal(t, []string{"0", "1", "2"}, order)
+		} else {
+			require.Equal(t, []string{"2", "1", "0"}, order)
+		}
+	}
+
+	err := NewRunner(testTargets{}, nil).Run(t.Context(), nil, &Options{Schedule: "lifo"})
 	require.Error(t, err)
 }
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	// A target's priority is the sum of its estimate and the priority of its dependent.
+	r := NewRunner(estimatedTargets{
+		testTargets: testTargets{
+			"root": testTarget(func(engine Engine) error {
+				return engine.EvaluateTargets(t.Context(), "a")[0].Error
+			}),
+			"a": testTarget(func(engine Engine) error {
+				return engine.EvaluateTargets(t.Context(), "b")[0].Error
+			}),
+			"b": testTarget(func(_ Engine) error { return nil }),
+		},
+		estimates: map[string]time.Duration{"root": 1, "a": 10, "b": 100},
+	}, nil)
+	err := r.Run(t.Context(), []string{"root"}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, int64(1), r.getTarget("root").priority.Load())
+	require.Equal(t, int64(11), r.getTarget("a").priority.Load())
+	require.Equal(t, int64(111), r.getTarget("b").priority.Load())
+}
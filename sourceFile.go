@@ -1,19 +1,22 @@
 package dawn
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/pgavlin/dawn/diff"
 	"github.com/pgavlin/dawn/label"
+	"github.com/pgavlin/dawn/runner"
 	"github.com/pgavlin/starlark-go/starlark"
 )
 
@@ -106,7 +109,7 @@ func (f *sourceFile) info() targetInfo {
 }
 
 func (f *sourceFile) upToDate(ctx context.Context) (bool, string, diff.ValueDiff, error) {
-	sum, err := fileSum(ctx, f.path)
+	sum, err := f.proj.fileSum(ctx, f.path)
 	if err != nil && !os.IsNotExist(err) {
 		return false, "", nil, err
 	}
@@ -119,9 +122,10 @@ func (f *sourceFile) upToDate(ctx context.Context) (bool, string, diff.ValueDiff
 	return false, "file contents changed", nil, nil
 }
 
-func (f *sourceFile) evaluate(_ context.Context) (data string, changed bool, err error) {
+func (f *sourceFile) evaluate(_ context.Context, _ runner.Engine) (data string, changed bool, err error) {
+	changed = f.oldSum != f.sum
 	f.oldSum = f.sum
-	return f.sum, true, nil
+	return f.sum, changed, nil
 }
 
 func (f *sourceFile) load() error {
@@ -135,7 +139,11 @@ func (f *sourceFile) load() error {
 	return nil
 }
 
-func fileSum(ctx context.Context, path string) (string, error) {
+// fileSum returns the SHA-256 sum of the file or directory at the given path. The sum of a
+// regular file covers its contents. The sum of a directory covers the name, type, permission
+// bits, and contents (or link target) of each of its entries, excluding entries that are
+// ignored by the project's configuration.
+func (proj *Project) fileSum(ctx context.Context, path string) (string, error) {
 	//nolint:gosec
 	f, err := os.Open(path)
 	if err != nil {
@@ -148,39 +156,54 @@ func fileSum(ctx context.Context, path string) (string, error) {
 		return "", err
 	}
 	if stat.IsDir() {
-		return dirSum(ctx, path, f)
-	}
-
-	h := sha256.New()
-	buf := make([]byte, max(min(64<<20, stat.Size()), 1))
-	for {
-		if err := ctx.Err(); err != nil {
-			return "", err
-		}
-		n, err := f.Read(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return hex.EncodeToString(h.Sum(nil)), nil
-			}
-			return "", err
-		}
-		h.Write(buf[:n])
+		return proj.dirSum(ctx, path, f)
 	}
+	return proj.sums.fileSum(ctx, path, f, stat)
 }
 
-func dirSum(ctx context.Context, path string, dir *os.File) (string, error) {
+func (proj *Project) dirSum(ctx context.Context, path string, dir *os.File) (string, error) {
 	entries, err := dir.ReadDir(0)
 	if err != nil {
 		return "", err
 	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return cmp.Compare(a.Name(), b.Name()) })
 
 	h := sha256.New()
 	for _, entry := range entries {
-		sum, err := fileSum(ctx, filepath.Join(path, entry.Name()))
+		entryPath := filepath.Join(path, entry.Name())
+		if entryPath == proj.work || proj.ignored(proj.relPath(entryPath)) {
+			continue
+		}
+
+		info, err := entry.Info()
 		if err != nil {
+			if os.IsNotExist(err) {
+				// The entry was removed after the directory was read.
+				continue
+			}
 			return "", err
 		}
-		h.Write([]byte(sum))
+
+		var kind byte
+		var contents string
+		switch {
+		case info.Mode().IsRegular():
+			kind = 'f'
+			contents, err = proj.fileSum(ctx, entryPath)
+		case info.IsDir():
+			kind = 'd'
+			contents, err = proj.fileSum(ctx, entryPath)
+		case info.Mode()&fs.ModeSymlink != 0:
+			kind = 'l'
+			contents, err = os.Readlink(entryPath)
+		default:
+			kind = 'o'
+		}
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "%c %o %s\x00%s\x00", kind, info.Mode().Perm(), entry.Name(), contents)
 	}
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
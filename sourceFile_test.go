@@ -0,0 +1,70 @@
+package dawn
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pgavlin/glob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSum(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	ignore, err := glob.New([]string{"src/ignored.txt"}, nil)
+	require.NoError(t, err)
+	proj := &Project{root: root, work: filepath.Join(root, ".dawn", "build"), ignore: ignore}
+
+	src := filepath.Join(root, "src")
+	require.NoError(t, os.MkdirAll(src, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("b"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "ignored.txt"), []byte("ignored"), 0o600))
+
+	sum := func() string {
+		sum, err := proj.fileSum(t.Context(), src)
+		require.NoError(t, err)
+		return sum
+	}
+	last := sum()
+
+	// Changes to ignored files do not affect the sum.
+	require.NoError(t, os.WriteFile(filepath.Join(src, "ignored.txt"), []byte("changed"), 0o600))
+	assert.Equal(t, last, sum())
+
+	// Swapping the contents of two files changes the sum.
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("b"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("a"), 0o600))
+	next := sum()
+	assert.NotEqual(t, last, next)
+	last = next
+
+	// Renaming a file changes the sum.
+	require.NoError(t, os.Rename(filepath.Join(src, "b.txt"), filepath.Join(src, "c.txt")))
+	next = sum()
+	assert.NotEqual(t, last, next)
+	last = next
+
+	if runtime.GOOS != "windows" {
+		// Changing a file's permission bits changes the sum.
+		require.NoError(t, os.Chmod(filepath.Join(src, "a.txt"), 0o700))
+		next = sum()
+		assert.NotEqual(t, last, next)
+		last = next
+
+		// Changing a symlink's target changes the sum.
+		require.NoError(t, os.Symlink("a.txt", filepath.Join(src, "link")))
+		next = sum()
+		assert.NotEqual(t, last, next)
+		last = next
+
+		require.NoError(t, os.Remove(filepath.Join(src, "link")))
+		require.NoError(t, os.Symlink("c.txt", filepath.Join(src, "link")))
+		next = sum()
+		assert.NotEqual(t, last, next)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || netbsd
+
+package dawn
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// statID returns the inode number and change time (in nanoseconds since the Unix epoch) of the
+// file described by info.
+func statID(info fs.FileInfo) (inode uint64, ctime int64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	sec, nsec := st.Ctimespec.Unix()
+	return uint64(st.Ino), sec*1e9 + nsec //nolint:unconvert
+}
@@ -0,0 +1,201 @@
+package dawn
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sugawarayuuta/sonnet"
+)
+
+// defaultRacyWindow is the default minimum age of a file's modification and change times for
+// its sum to be cached. Files modified more recently than this may be modified again without
+// their metadata changing (e.g. if the filesystem's timestamps have a granularity of one
+// second), so their sums are always recomputed.
+const defaultRacyWindow = 2 * time.Second
+
+// A fileSumEntry records the SHA-256 sum of a file's contents along with the file metadata
+// that was observed when the sum was computed.
+type fileSumEntry struct {
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"`
+	Ctime int64  `json:"ctime,omitempty"`
+	Inode uint64 `json:"inode,omitempty"`
+	Sum   string `json:"sum"`
+}
+
+func newFileSumEntry(info fs.FileInfo) fileSumEntry {
+	inode, ctime := statID(info)
+	return fileSumEntry{
+		Size:  info.Size(),
+		Mtime: info.ModTime().UnixNano(),
+		Ctime: ctime,
+		Inode: inode,
+	}
+}
+
+// A fileSumCache is a persistent cache of file sums. Each entry is keyed by the file's path and
+// is only valid as long as the file's size, modification time, inode number, and change time
+// are unchanged. Entries for files that no longer exist are pruned when the cache is saved.
+//
+// A nil *fileSumCache is valid and caches nothing.
+type fileSumCache struct {
+	m       sync.Mutex
+	path    string
+	entries map[string]fileSumEntry
+	used    map[string]bool // the paths that have been looked up since the cache was last saved
+	dirty   bool
+
+	racyWindow time.Duration
+}
+
+// loadFileSumCache loads the file sum cache stored at the given path. If the cache does not
+// exist or cannot be read, the returned cache is empty.
+func loadFileSumCache(path string) *fileSumCache {
+	c := &fileSumCache{path: path, entries: map[string]fileSumEntry{}, used: map[string]bool{}, racyWindow: defaultRacyWindow}
+
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return c
+	}
+	defer f.Close()
+
+	var entries map[string]fileSumEntry
+	if err := sonnet.NewDecoder(f).Decode(&entries); err == nil && entries != nil {
+		c.entries = entries
+	}
+	return c
+}
+
+// save writes the cache to disk if it has changed since it was loaded. Before the cache is
+// written, the entries that have not been looked up since the cache was last saved are removed if
+// their files no longer exist.
+func (c *fileSumCache) save(temp string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.prune()
+	if !c.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(temp, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(temp, "")
+	if err != nil {
+		return err
+	}
+	tempName := f.Name()
+
+	if err = sonnet.NewEncoder(f).Encode(c.entries); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tempName, c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// prune removes the entries for files that have not been looked up since the cache was last
+// saved and no longer exist. The files that were looked up existed at the time, so they are not
+// examined. c.m must be held.
+func (c *fileSumCache) prune() {
+	for path := range c.entries {
+		if c.used[path] {
+			continue
+		}
+		if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+			delete(c.entries, path)
+			c.dirty = true
+		}
+	}
+	clear(c.used)
+}
+
+func (c *fileSumCache) lookup(path string, key fileSumEntry) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.used[path] = true
+
+	entry, ok := c.entries[path]
+	if !ok {
+		return "", false
+	}
+	sum := entry.Sum
+	entry.Sum = ""
+	if entry != key {
+		return "", false
+	}
+	return sum, true
+}
+
+func (c *fileSumCache) store(path string, key fileSumEntry, sum string, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	// If the file was modified too recently, a subsequent modification may not be
+	// reflected in its metadata. Don't cache its sum.
+	changed := max(key.Mtime, key.Ctime)
+	if now.UnixNano()-changed < int64(c.racyWindow) {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	key.Sum = sum
+	c.entries[path] = key
+	c.dirty = true
+}
+
+// fileSum returns the SHA-256 sum of the contents of the given regular file, which must have
+// been opened from the given path. Sums are looked up in and recorded to the cache.
+func (c *fileSumCache) fileSum(ctx context.Context, path string, f *os.File, info fs.FileInfo) (string, error) {
+	key := newFileSumEntry(info)
+	if sum, ok := c.lookup(path, key); ok {
+		return sum, nil
+	}
+
+	now := time.Now()
+	h := sha256.New()
+	buf := make([]byte, max(min(64<<20, info.Size()), 1))
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := f.Read(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				sum := hex.EncodeToString(h.Sum(nil))
+				c.store(path, key, sum, now)
+				return sum, nil
+			}
+			return "", err
+		}
+		h.Write(buf[:n])
+	}
+}
@@ -0,0 +1,119 @@
+package dawn
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cachedFileSum(t *testing.T, c *fileSumCache, path string) (string, error) {
+	//nolint:gosec
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	return c.fileSum(t.Context(), path, f, stat)
+}
+
+func TestFileSumCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o600))
+
+	// Use an empty racy window so that the freshly-written file is cached.
+	cachePath := filepath.Join(dir, "sums.json")
+	c := loadFileSumCache(cachePath)
+	c.racyWindow = 0
+
+	sum, err := cachedFileSum(t, c, path)
+	require.NoError(t, err)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", sum)
+
+	// Prove that the cached sum is used by replacing it.
+	entry, ok := c.entries[path]
+	require.True(t, ok)
+	entry.Sum = "cached"
+	c.entries[path] = entry
+
+	sum, err = cachedFileSum(t, c, path)
+	require.NoError(t, err)
+	assert.Equal(t, "cached", sum)
+
+	// The cache should survive a round trip.
+	require.NoError(t, c.save(filepath.Join(dir, "temp")))
+	c = loadFileSumCache(cachePath)
+	c.racyWindow = 0
+
+	sum, err = cachedFileSum(t, c, path)
+	require.NoError(t, err)
+	assert.Equal(t, "cached", sum)
+
+	// Rewriting the file with contents of the same size and restoring its modification time
+	// must not yield a stale sum on platforms that record change times.
+	if runtime.GOOS != "windows" {
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte("world"), 0o600))
+		require.NoError(t, os.Chtimes(path, stat.ModTime(), stat.ModTime()))
+
+		sum, err = cachedFileSum(t, c, path)
+		require.NoError(t, err)
+		assert.Equal(t, "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7", sum)
+	}
+}
+
+func TestFileSumCachePrune(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	require.NoError(t, os.WriteFile(a, []byte("a"), 0o600))
+	require.NoError(t, os.WriteFile(b, []byte("b"), 0o600))
+
+	cachePath := filepath.Join(dir, "sums.json")
+	c := loadFileSumCache(cachePath)
+	c.racyWindow = 0
+
+	_, err := cachedFileSum(t, c, a)
+	require.NoError(t, err)
+	_, err = cachedFileSum(t, c, b)
+	require.NoError(t, err)
+	require.NoError(t, c.save(filepath.Join(dir, "temp")))
+
+	// Entries for removed files are dropped, while entries for files that were not looked up but
+	// still exist are retained.
+	require.NoError(t, os.Remove(b))
+	c = loadFileSumCache(cachePath)
+	require.NoError(t, c.save(filepath.Join(dir, "temp")))
+
+	c = loadFileSumCache(cachePath)
+	assert.Contains(t, c.entries, a)
+	assert.NotContains(t, c.entries, b)
+}
+
+func TestFileSumCacheRacy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o600))
+
+	c := loadFileSumCache(filepath.Join(dir, "sums.json"))
+	c.racyWindow = time.Hour
+
+	_, err := cachedFileSum(t, c, path)
+	require.NoError(t, err)
+
+	// The file was modified within the racy window, so its sum must not be cached.
+	_, ok := c.entries[path]
+	assert.False(t, ok)
+}
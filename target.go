@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/pgavlin/dawn/diff"
 	"github.com/pgavlin/dawn/label"
@@ -18,6 +20,15 @@ import (
 
 var ErrDependenciesFailed = errors.New("dependencies failed")
 
+// A TimeoutError is returned when a target does not finish within its timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", e.Timeout)
+}
+
 // A Target represents a build target within a Project.
 type Target interface {
 	starlark.Value
@@ -38,8 +49,9 @@ type Target interface {
 	dependencies() []string
 	generates() []string
 	info() targetInfo
+	load() error
 	upToDate(ctx context.Context) (bool, string, diff.ValueDiff, error)
-	evaluate(ctx context.Context) (data string, changed bool, err error)
+	evaluate(ctx context.Context, engine runner.Engine) (data string, changed bool, err error)
 }
 
 // runTarget implements runner.Target.
@@ -49,20 +61,42 @@ type runTarget struct {
 	data    string
 }
 
-func (t *runTarget) Evaluate(ctx context.Context, engine runner.Engine) error {
+// Resources returns the resources required by the target.
+func (t *runTarget) Resources() map[string]int {
+	if f, ok := t.target.(*function); ok {
+		return f.resources
+	}
+	return nil
+}
+
+func (t *runTarget) Evaluate(ctx context.Context, engine runner.Engine) (err error) {
 	proj, label, info := t.target.Project(), t.target.Label(), t.target.info()
 
+	// Record failures in the project's run report.
+	defer func() {
+		if err != nil && !errors.Is(err, ErrDependenciesFailed) {
+			proj.report.targetFailed(label.String(), err)
+		}
+	}()
+
 	// Copy the current version of the data.
 	t.data = info.Data
 
-	// Evaluate the target's dependencies.
+	// Evaluate the target's dependencies, including any targets it required during its last
+	// evaluation.
 	depsUpToDate := true
-	deps := t.target.dependencies()
+	static := t.target.dependencies()
+	deps := static
+	for _, dep := range slices.Sorted(maps.Keys(requiredTargets(t.target))) {
+		if !slices.Contains(static, dep) {
+			deps = append(slices.Clip(deps), dep)
+		}
+	}
 
 	depData := map[string]string{}
 	var cyclicDepErr *runner.CyclicDependencyError
 	var missingDeps []string
-	var hasFailedDeps bool
+	var failedDeps []string
 	var outOfDateDeps []string
 	if len(deps) != 0 {
 		proj.events.TargetWaiting(label, deps)
@@ -70,20 +104,27 @@ func (t *runTarget) Evaluate(ctx context.Context, engine runner.Engine) error {
 			if dep.Error != nil {
 				switch err := dep.Error.(type) {
 				case UnknownTargetError:
+					// A target that was required by the target's last evaluation but no longer
+					// exists only means that the target is out-of-date.
+					if i >= len(static) {
+						outOfDateDeps = append(outOfDateDeps, deps[i])
+						depsUpToDate = false
+						continue
+					}
 					missingDeps = append(missingDeps, err.Error())
 				case *runner.CyclicDependencyError:
 					if cyclicDepErr == nil || cyclicDepErr.On >= err.On {
 						cyclicDepErr = err
 					}
 				default:
-					hasFailedDeps = true
+					failedDeps = append(failedDeps, deps[i])
 				}
 				continue
 			}
 
 			label := deps[i]
 
-			newData := dep.Target.(*runTarget).data
+			newData := dep.Target.(*runTarget).stamp()
 			depData[label] = newData
 
 			prevData, ok := info.Dependencies[label]
@@ -119,7 +160,8 @@ func (t *runTarget) Evaluate(ctx context.Context, engine runner.Engine) error {
 	}
 
 	// Check for failed deps.
-	if hasFailedDeps {
+	if len(failedDeps) != 0 {
+		proj.report.targetBlocked(label.String(), failedDeps)
 		return ErrDependenciesFailed
 	}
 
@@ -157,8 +199,13 @@ func (t *runTarget) Evaluate(ctx context.Context, engine runner.Engine) error {
 		return nil
 	}
 
-	// Otherwise, evaluate the target.
-	data, changed, err := t.target.evaluate(ctx)
+	// Otherwise, evaluate the target. A target whose results are restored from the action cache
+	// retains the duration of its last run so that its estimated duration reflects the time
+	// needed to run it.
+	data, changed, duration, err := t.evaluate(ctx, engine, depData)
+	if duration == 0 {
+		duration = info.Duration
+	}
 	if err != nil {
 		proj.events.TargetFailed(label, err)
 
@@ -169,20 +216,59 @@ func (t *runTarget) Evaluate(ctx context.Context, engine runner.Engine) error {
 			Dependencies: info.Dependencies,
 			Data:         t.data,
 			Rerun:        true,
+			Duration:     info.Duration,
+			Discovered:   discoveredSources(t.target),
+			Required:     slices.Sorted(maps.Keys(requiredTargets(t.target))),
 		})
 		return errors.Join(saveErr, err)
 	}
 
-	// Save the target's metadata.
-	t.changed = changed
-	if changed {
-		t.data = data
+	// Audited runs do not produce a function's real outputs. Rather than recording the results of
+	// the run, record that the function must be re-run by the next build.
+	if f, ok := t.target.(*function); ok && proj.auditing() {
+		t.changed, t.data = changed, data
+		f.targetInfo.Rerun = true
+		err = proj.saveTargetInfo(label, targetInfo{
+			Doc:          t.target.Doc(),
+			Pos:          t.target.Pos(),
+			Dependencies: info.Dependencies,
+			Data:         info.Data,
+			Rerun:        true,
+			Duration:     info.Duration,
+			Discovered:   info.Discovered,
+			Required:     info.Required,
+		})
+		if err != nil {
+			proj.events.TargetFailed(label, err)
+			return err
+		}
+		proj.events.TargetSucceeded(label, changed)
+		return nil
+	}
+
+	// Save the target's metadata. Note that the target's data is updated even if the target
+	// reports that it has not changed: the data may record state (e.g. a function's
+	// environment) that does not affect the target's dependents.
+	//
+	// The recorded dependencies are the target's static dependencies and the targets it required
+	// during this evaluation.
+	t.changed, t.data = changed, data
+	required := requiredTargets(t.target)
+	dependencies := make(map[string]string, len(static)+len(required))
+	for _, dep := range static {
+		if data, ok := depData[dep]; ok {
+			dependencies[dep] = data
+		}
 	}
+	maps.Copy(dependencies, required)
 	err = proj.saveTargetInfo(label, targetInfo{
 		Doc:          t.target.Doc(),
 		Pos:          t.target.Pos(),
-		Dependencies: depData,
+		Dependencies: dependencies,
 		Data:         t.data,
+		Duration:     duration,
+		Discovered:   discoveredSources(t.target),
+		Required:     slices.Sorted(maps.Keys(required)),
 	})
 	if err != nil {
 		proj.events.TargetFailed(label, err)
@@ -192,6 +278,82 @@ func (t *runTarget) Evaluate(ctx context.Context, engine runner.Engine) error {
 	return nil
 }
 
+// discoveredSources returns the sums of the sources listed in the given target's depfile after
+// its last successful evaluation.
+func discoveredSources(t Target) map[string]string {
+	if f, ok := t.(*function); ok {
+		return f.discovered
+	}
+	return nil
+}
+
+// requiredTargets returns the stamps of the targets required by the given target's last
+// successful evaluation, keyed by label.
+func requiredTargets(t Target) map[string]string {
+	if f, ok := t.(*function); ok {
+		return f.required
+	}
+	return nil
+}
+
+// evaluate evaluates the target. If the target is a cacheable function, its results are restored
+// from the project's action cache if possible and are recorded in the cache otherwise. The
+// returned duration is the time spent running the target, and is zero if the target's results
+// were restored.
+func (t *runTarget) evaluate(ctx context.Context, engine runner.Engine, depData map[string]string) (string, bool, time.Duration, error) {
+	f, ok := t.target.(*function)
+	if !ok || !f.cacheable() {
+		start := time.Now()
+		data, changed, err := t.target.evaluate(ctx, engine)
+		return data, changed, time.Since(start), err
+	}
+
+	// The targets required by the function's last evaluation are not part of its key. Instead,
+	// the targets required by a cached result are checked when the result is restored.
+	static := make(map[string]string, len(f.deps))
+	for _, dep := range f.deps {
+		if data, ok := depData[dep]; ok {
+			static[dep] = data
+		}
+	}
+	key, err := f.actionKey(static)
+	if err != nil {
+		return "", false, 0, err
+	}
+
+	// If the target is being forced to re-run, skip the cache lookup.
+	if !f.proj.always {
+		if result, remote, ok := f.proj.actions.load(ctx, key); ok {
+			if data, changed, ok := f.restore(ctx, engine, result); ok {
+				f.proj.events.TargetCacheHit(f.label, remote)
+				return data, changed, 0, nil
+			}
+		}
+		f.proj.events.TargetCacheMiss(f.label)
+	}
+
+	start := time.Now()
+	data, changed, err := f.evaluate(ctx, engine)
+	duration := time.Since(start)
+	if err != nil {
+		return "", false, duration, err
+	}
+
+	// Caching is best-effort: failing to record the target's results does not fail the target.
+	_ = f.cache(ctx, key, data)
+
+	return data, changed, duration, nil
+}
+
+// stamp returns the value recorded for the target by its dependents. If the stamp is unchanged
+// since a dependent last ran, the dependent need not re-run on the target's account.
+func (t *runTarget) stamp() string {
+	if f, ok := t.target.(*function); ok && len(f.gens) != 0 {
+		return f.stamp()
+	}
+	return t.data
+}
+
 func targetDependencies(t Target) []*label.Label {
 	return slices.SortedFunc(
 		try.Must(fxs.MapUnpack(t.dependencies(), label.Parse)),
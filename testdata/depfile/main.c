@@ -0,0 +1 @@
+#include "include/util.h"
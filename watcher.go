@@ -0,0 +1,275 @@
+package dawn
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rjeczalik/notify"
+)
+
+// DefaultPollInterval is the interval at which Watch polls for changes if WatchOptions does not
+// specify an interval.
+const DefaultPollInterval = time.Second
+
+// A watcher reports changes to the files in a project.
+type watcher interface {
+	// Changes returns the channel that receives the paths of changed files.
+	Changes() <-chan string
+	// Close stops the watcher.
+	Close() error
+}
+
+// newWatcher returns a watcher for the project. If the options request polling or the platform's
+// file watcher cannot be started, the returned watcher polls the project's module files and
+// sources for changes.
+func (proj *Project) newWatcher(options *WatchOptions) watcher {
+	interval := options.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	if !options.Poll {
+		w, err := newNotifyWatcher(proj.root)
+		if err == nil {
+			return w
+		}
+		proj.events.WatchPolling(interval, err)
+	} else {
+		proj.events.WatchPolling(interval, nil)
+	}
+	return newPollWatcher(interval, proj.watchedPaths)
+}
+
+// watchedPaths returns the paths that are polled for changes: the paths of the project's modules
+// and sources, and the directories that contain them.
+func (proj *Project) watchedPaths() []string {
+	proj.m.Lock()
+	defer proj.m.Unlock()
+
+	paths := map[string]struct{}{proj.root: {}}
+	add := func(path string) {
+		paths[path] = struct{}{}
+		paths[filepath.Dir(path)] = struct{}{}
+	}
+	for _, m := range proj.modules {
+		if m.path != "" && m.label.Project == "" {
+			add(m.path)
+		}
+	}
+	for _, t := range proj.targets {
+		if f, ok := t.target.(*sourceFile); ok {
+			add(f.path)
+		}
+	}
+
+	result := make([]string, 0, len(paths))
+	for path := range paths {
+		result = append(result, path)
+	}
+	return result
+}
+
+// A notifyWatcher watches a project using the platform's file watcher.
+type notifyWatcher struct {
+	events  chan notify.EventInfo
+	changes chan string
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newNotifyWatcher(root string) (*notifyWatcher, error) {
+	events := make(chan notify.EventInfo, 1000)
+	if err := notify.Watch(filepath.Join(root, "..."), events, notify.All); err != nil {
+		return nil, err
+	}
+
+	w := &notifyWatcher{events: events, changes: make(chan string), done: make(chan struct{})}
+	go func() {
+		defer close(w.changes)
+		for {
+			select {
+			case event := <-w.events:
+				select {
+				case w.changes <- event.Path():
+				case <-w.done:
+					return
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+func (w *notifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *notifyWatcher) Close() error {
+	w.once.Do(func() {
+		notify.Stop(w.events)
+		close(w.done)
+	})
+	return nil
+}
+
+// A pollWatcher watches a project by periodically examining a set of paths. A file changes if
+// its size or modification time changes, or if it is created or removed. Changes to the contents
+// of a directory are reported as changes to the files that were added to or removed from the
+// directory.
+type pollWatcher struct {
+	interval time.Duration
+	paths    func() []string
+	changes  chan string
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newPollWatcher(interval time.Duration, paths func() []string) *pollWatcher {
+	w := &pollWatcher{
+		interval: interval,
+		paths:    paths,
+		changes:  make(chan string),
+		done:     make(chan struct{}),
+	}
+	go w.poll()
+	return w
+}
+
+func (w *pollWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *pollWatcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+// poll examines the watcher's paths at each interval until the watcher is closed.
+func (w *pollWatcher) poll() {
+	defer close(w.changes)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	states := w.scan(nil)
+	for {
+		select {
+		case <-ticker.C:
+			states = w.scan(states)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// scan examines the watcher's paths and reports the changes since the given states were
+// recorded. Paths that were not previously examined are recorded without reporting a change. Each
+// changed path is reported once per scan, even if it is also an entry of a changed directory.
+func (w *pollWatcher) scan(states map[string]pathState) map[string]pathState {
+	paths := w.paths()
+	next := make(map[string]pathState, len(paths))
+	reported := map[string]bool{}
+	report := func(path string) bool {
+		if reported[path] {
+			return true
+		}
+		reported[path] = true
+		return w.report(path)
+	}
+
+	for _, path := range paths {
+		state := statPath(path)
+		next[path] = state
+
+		old, ok := states[path]
+		if !ok || old == state {
+			continue
+		}
+		if old.dir && state.dir {
+			for _, name := range diffEntries(old.entries, state.entries) {
+				if !report(filepath.Join(path, name)) {
+					return next
+				}
+			}
+			continue
+		}
+		if !report(path) {
+			return next
+		}
+	}
+	return next
+}
+
+// report sends the given path to the watcher's channel. It returns false if the watcher was
+// closed.
+func (w *pollWatcher) report(path string) bool {
+	select {
+	case w.changes <- path:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// A pathState records the state of a polled path.
+type pathState struct {
+	exists  bool
+	dir     bool
+	size    int64
+	modTime int64  // the modification time of a file in nanoseconds since the Unix epoch
+	entries string // the sorted, NUL-separated names of a directory's entries
+}
+
+func statPath(path string) pathState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return pathState{}
+	}
+	if !info.IsDir() {
+		return pathState{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano()}
+	}
+
+	// The modification times of directories are not reliable on all filesystems, so
+	// directories are compared by their entries.
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return pathState{exists: true, dir: true}
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return pathState{exists: true, dir: true, entries: strings.Join(names, "\x00")}
+}
+
+// diffEntries returns the names that are present in exactly one of the given lists of directory
+// entries.
+func diffEntries(old, new string) []string {
+	oldNames, newNames := map[string]bool{}, map[string]bool{}
+	for _, name := range strings.Split(old, "\x00") {
+		oldNames[name] = true
+	}
+	for _, name := range strings.Split(new, "\x00") {
+		newNames[name] = true
+	}
+
+	var names []string
+	for name := range oldNames {
+		if name != "" && !newNames[name] {
+			names = append(names, name)
+		}
+	}
+	for name := range newNames {
+		if name != "" && !oldNames[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
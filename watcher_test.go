@@ -0,0 +1,55 @@
+package dawn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollWatcher(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	require.NoError(t, os.WriteFile(a, []byte("a"), 0o600))
+
+	w := newPollWatcher(10*time.Millisecond, func() []string { return []string{dir, a} })
+	defer w.Close()
+
+	// next returns the next changed path.
+	next := func() string {
+		select {
+		case path := <-w.Changes():
+			return path
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for a change")
+			return ""
+		}
+	}
+
+	// Wait for the first scan to record the initial states.
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(a, []byte("aa"), 0o600))
+	assert.Equal(t, a, next())
+
+	require.NoError(t, os.WriteFile(b, []byte("b"), 0o600))
+	assert.Equal(t, b, next())
+
+	// A removed file is reported once, even though its directory also changed.
+	require.NoError(t, os.Remove(a))
+	assert.Equal(t, a, next())
+	select {
+	case path := <-w.Changes():
+		assert.Fail(t, "unexpected change", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, w.Close())
+	for range w.Changes() {
+	}
+}